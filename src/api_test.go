@@ -0,0 +1,463 @@
+// src/api_test.go
+// go test ./src -v
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupRouter returns the server's router backed by an in-memory store
+// holding the dummy data, so the tests need no running Redis
+func setupRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	store := NewMemoryStore()
+	seedDummyData(store)
+	return NewServer(store, NewFileArtifactStore(cfg.Paths)).setupRouter()
+}
+
+func TestCoreUpdateCheck(t *testing.T) {
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/core-update-check/?version=6.1.1&php=8.1.2&mysql=8.0.35&locale=en_US", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+
+	offers, ok := response["offers"].([]interface{})
+	assert.True(t, ok)
+	assert.Len(t, offers, 3)
+
+	offer := offers[0].(map[string]interface{})
+	assert.Equal(t, "upgrade", offer["response"])
+	assert.Equal(t, "6.2.1", offer["version"])
+	assert.Contains(t, offer, "php_version")
+	assert.Contains(t, offer, "mysql_version")
+	assert.Contains(t, offer, "download")
+	assert.Contains(t, offer, "packages")
+
+	assert.Equal(t, "autoupdate", offers[1].(map[string]interface{})["response"])
+	assert.Equal(t, "6.2.1", offers[1].(map[string]interface{})["version"])
+	assert.Equal(t, "autoupdate", offers[2].(map[string]interface{})["response"])
+	assert.Equal(t, "6.1.3", offers[2].(map[string]interface{})["version"])
+}
+
+func TestCoreUpdateCheckLatest(t *testing.T) {
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/core-update-check/?version=6.2.1&locale=en_US", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+
+	var response struct {
+		Offers []CoreOffer `json:"offers"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+
+	assert.Len(t, response.Offers, 1)
+	assert.Equal(t, "latest", response.Offers[0].Response)
+	assert.Equal(t, "6.2.1", response.Offers[0].Version)
+}
+
+func TestPluginInfoBulk(t *testing.T) {
+	router := setupRouter()
+
+	requestBody := map[string]string{
+		"contact-form-7/wp-contact-form-7.php": "contact-form-7",
+		"akismet/akismet.php":                  "akismet",
+	}
+	jsonBody, _ := json.Marshal(requestBody)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/plugin-info-bulk/", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+
+	for pluginFile, _ := range requestBody {
+		pluginInfo, ok := response[pluginFile].(map[string]interface{})
+		assert.True(t, ok)
+		assert.Contains(t, pluginInfo, "slug")
+		assert.Contains(t, pluginInfo, "new_version")
+		assert.Contains(t, pluginInfo, "url")
+		assert.Contains(t, pluginInfo, "package")
+	}
+}
+
+func TestThemeInfoBulk(t *testing.T) {
+	router := setupRouter()
+
+	requestBody := []string{"twentytwentythree", "twentytwentytwo"}
+	jsonBody, _ := json.Marshal(requestBody)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/theme-info-bulk/", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+
+	for _, themeSlug := range requestBody {
+		themeInfo, ok := response[themeSlug].(map[string]interface{})
+		assert.True(t, ok)
+		assert.Contains(t, themeInfo, "theme")
+		assert.Contains(t, themeInfo, "new_version")
+		assert.Contains(t, themeInfo, "url")
+		assert.Contains(t, themeInfo, "package")
+	}
+}
+
+func TestCoreVersionCheckCompatible(t *testing.T) {
+	router := setupRouter()
+
+	// Query string as sent by wp_version_check()
+	query := "version=6.1.1&php=8.1.2&locale=en_US&mysql=8.0.35&local_package=&blogs=1&users=1&multisite_enabled=0&initial_db_version=53496"
+	form := "translations=%5B%5D"
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/core/version-check/1.7/?"+query, bytes.NewBufferString(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{}, response["translations"])
+
+	offers := response["offers"].([]interface{})
+	assert.Len(t, offers, 3)
+
+	offer := offers[0].(map[string]interface{})
+	for _, key := range []string{"response", "download", "locale", "packages", "current", "version", "php_version", "mysql_version", "new_bundled", "partial_version"} {
+		assert.Contains(t, offer, key)
+	}
+	packages := offer["packages"].(map[string]interface{})
+	assert.Equal(t, offer["download"], packages["full"])
+	assert.Equal(t, false, packages["no_content"])
+	assert.Equal(t, false, packages["partial"])
+	assert.Equal(t, false, packages["rollback"])
+}
+
+func TestPluginUpdateCheckCompatible(t *testing.T) {
+	router := setupRouter()
+
+	// Form fields as posted by wp_update_plugins()
+	plugins := `{"plugins":{"akismet/akismet.php":{"Name":"Akismet","Version":"4.2"},"contact-form-7/wp-contact-form-7.php":{"Name":"Contact Form 7","Version":"5.7.2"},"my-custom/my-custom.php":{"Name":"Custom","Version":"1.0"}},"active":["akismet/akismet.php"]}`
+	form := url.Values{
+		"plugins":      {plugins},
+		"translations": {"[]"},
+		"locale":       {`["en_US"]`},
+		"all":          {"true"},
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/plugins/update-check/1.1/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+
+	var response PluginUpdateCheckResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.NotNil(t, response.Translations)
+
+	assert.Len(t, response.Plugins, 1)
+	update := response.Plugins["akismet/akismet.php"]
+	assert.Equal(t, "w.org/plugins/akismet", update.ID)
+	assert.Equal(t, "akismet", update.Slug)
+	assert.Equal(t, "akismet/akismet.php", update.Plugin)
+	assert.Equal(t, "5.1", update.NewVersion)
+
+	var raw struct {
+		Plugins map[string]map[string]interface{} `json:"plugins"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &raw))
+	for _, key := range []string{"url", "package", "icons", "banners", "requires", "tested", "requires_php"} {
+		assert.Contains(t, raw.Plugins["akismet/akismet.php"], key)
+	}
+
+	assert.Len(t, response.NoUpdate, 1)
+	assert.Contains(t, response.NoUpdate, "contact-form-7/wp-contact-form-7.php")
+}
+
+func TestThemeUpdateCheckCompatible(t *testing.T) {
+	router := setupRouter()
+
+	// Form fields as posted by wp_update_themes()
+	themes := `{"active":"twentytwentythree","themes":{"twentytwentythree":{"Name":"Twenty Twenty-Three","Version":"1.0","Template":"twentytwentythree","Stylesheet":"twentytwentythree"},"twentytwentytwo":{"Name":"Twenty Twenty-Two","Version":"1.4"},"my-child-theme":{"Name":"Child","Version":"1.0"}}}`
+	form := url.Values{
+		"themes":       {themes},
+		"translations": {"[]"},
+		"locale":       {`["en_US"]`},
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/themes/update-check/1.1/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+
+	var response ThemeUpdateCheckResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.NotNil(t, response.Translations)
+
+	assert.Len(t, response.Themes, 1)
+	update := response.Themes["twentytwentythree"]
+	assert.Equal(t, "twentytwentythree", update.Theme)
+	assert.Equal(t, "1.1", update.NewVersion)
+	assert.NotEmpty(t, update.Package)
+
+	assert.Len(t, response.NoUpdate, 1)
+	assert.Contains(t, response.NoUpdate, "twentytwentytwo")
+}
+
+func TestPluginInformation(t *testing.T) {
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/plugins/info/1.2/?action=plugin_information&request[slug]=akismet", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+
+	var info PluginInfo
+	err := json.Unmarshal(w.Body.Bytes(), &info)
+	assert.NoError(t, err)
+	assert.Equal(t, "akismet", info.Slug)
+	assert.Equal(t, "5.1", info.Version)
+	assert.NotEmpty(t, info.Sections["description"])
+	assert.Contains(t, info.Versions, "5.1")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/plugins/info/1.2/?action=plugin_information&request[slug]=missing", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestQueryPlugins(t *testing.T) {
+	router := setupRouter()
+
+	tests := []struct {
+		name    string
+		query   string
+		slugs   []string
+		pages   int
+		results int
+	}{
+		{"all", "", []string{"akismet", "contact-form-7"}, 1, 2},
+		{"search", "&request[search]=spam", []string{"akismet"}, 1, 1},
+		{"tag", "&request[tag]=contact-form", []string{"contact-form-7"}, 1, 1},
+		{"author", "&request[author]=takayukister", []string{"contact-form-7"}, 1, 1},
+		{"page", "&request[browse]=new&request[per_page]=1&request[page]=2", []string{"akismet"}, 2, 2},
+		{"past last page", "&request[page]=3", nil, 1, 2},
+		{"huge page", "&request[per_page]=100&request[page]=4611686018427387904", nil, 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/plugins/info/1.2/?action=query_plugins"+tt.query, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, 200, w.Code)
+
+			var response struct {
+				Info    QueryInfo    `json:"info"`
+				Plugins []PluginInfo `json:"plugins"`
+			}
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.pages, response.Info.Pages)
+			assert.Equal(t, tt.results, response.Info.Results)
+
+			var slugs []string
+			for _, p := range response.Plugins {
+				assert.Empty(t, p.Sections)
+				slugs = append(slugs, p.Slug)
+			}
+			assert.ElementsMatch(t, tt.slugs, slugs)
+		})
+	}
+}
+
+func TestThemeInformation(t *testing.T) {
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/themes/info/1.1/?action=theme_information&request[slug]=twentytwentythree", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+
+	var info ThemeInfo
+	err := json.Unmarshal(w.Body.Bytes(), &info)
+	assert.NoError(t, err)
+	assert.Equal(t, "twentytwentythree", info.Slug)
+	assert.Equal(t, "1.1", info.Version)
+	assert.Equal(t, "wordpressdotorg", info.AuthorName())
+	assert.NotEmpty(t, info.Sections["description"])
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/themes/info/1.1/?action=theme_information&request[slug]=missing", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestQueryThemes(t *testing.T) {
+	router := setupRouter()
+
+	tests := []struct {
+		name    string
+		query   string
+		slugs   []string
+		results int
+	}{
+		{"popular", "&request[browse]=popular", []string{"twentytwentytwo", "twentytwentythree"}, 2},
+		{"new", "&request[browse]=new&request[per_page]=1", []string{"twentytwentythree"}, 2},
+		{"updated", "&request[browse]=updated&request[per_page]=1", []string{"twentytwentythree"}, 2},
+		{"search", "&request[search]=site+editor", []string{"twentytwentytwo", "twentytwentythree"}, 2},
+		{"all tags", "&request[tag][]=blog&request[tag][]=one-column", []string{"twentytwentythree"}, 1},
+		{"author", "&request[author]=wordpressdotorg", []string{"twentytwentytwo", "twentytwentythree"}, 2},
+		{"huge page", "&request[per_page]=100&request[page]=4611686018427387904", nil, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/themes/info/1.1/?action=query_themes"+tt.query, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, 200, w.Code)
+
+			var response struct {
+				Info   QueryInfo   `json:"info"`
+				Themes []ThemeInfo `json:"themes"`
+			}
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.results, response.Info.Results)
+
+			var slugs []string
+			for _, theme := range response.Themes {
+				assert.Empty(t, theme.Versions)
+				slugs = append(slugs, theme.Slug)
+			}
+			assert.Equal(t, tt.slugs, slugs)
+		})
+	}
+}
+
+func TestThemeHotTagsAndFeatureList(t *testing.T) {
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/themes/info/1.1/?action=hot_tags&request[number]=2", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+
+	var hotTags map[string]HotTag
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &hotTags))
+	assert.Len(t, hotTags, 2)
+	assert.Equal(t, 2, hotTags["blog"].Count)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/themes/info/1.1/?action=feature_list", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+
+	var features map[string][]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &features))
+	assert.Contains(t, features["Features"], "full-site-editing")
+}
+
+func TestQueryPluginsMatchesContributors(t *testing.T) {
+	store := NewMemoryStore()
+	require.NoError(t, store.SetPluginInfo(PluginInfo{
+		Slug:          "akismet",
+		AuthorProfile: "https://profiles.wordpress.org/automattic/",
+		Contributors:  json.RawMessage(`{"automattic":{},"kbrown9":{"profile":"https://profiles.wordpress.org/kbrown9/"}}`),
+	}))
+	router := NewServer(store, NewFileArtifactStore(cfg.Paths)).setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/plugins/info/1.2/?action=query_plugins&request[author]=kbrown9", nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	var response struct {
+		Plugins []map[string]interface{} `json:"plugins"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Plugins, 1)
+	assert.Equal(t, "akismet", response.Plugins[0]["slug"])
+	assert.NotContains(t, response.Plugins[0], "contributors")
+}
+
+func TestCoreUpdateCheckLocalizedBuilds(t *testing.T) {
+	for name, store := range map[string]MetadataStore{"memory": NewMemoryStore(), "bolt": openTestBoltStore(t)} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, store.SetCoreVersions([]CoreVersion{
+				{Version: "6.4.2", Locale: "en_US", Package: "https://downloads.wordpress.org/release/wordpress-6.4.2.zip"},
+				{Version: "6.4.2", Locale: "de_DE", Package: "https://downloads.wordpress.org/release/de_DE/wordpress-6.4.2.zip"},
+			}))
+			versions, err := store.GetCoreVersions()
+			require.NoError(t, err)
+			assert.Len(t, versions, 2)
+			router := NewServer(store, NewFileArtifactStore(cfg.Paths)).setupRouter()
+
+			for locale, want := range map[string]string{
+				"en_US": "https://downloads.wordpress.org/release/wordpress-6.4.2.zip",
+				"de_DE": "https://downloads.wordpress.org/release/de_DE/wordpress-6.4.2.zip",
+				"fr_FR": "https://downloads.wordpress.org/release/wordpress-6.4.2.zip",
+			} {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/core-update-check/?version=6.3.2&locale="+locale, nil)
+				router.ServeHTTP(w, req)
+				require.Equal(t, 200, w.Code)
+
+				var response struct {
+					Offers []CoreOffer `json:"offers"`
+				}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				require.NotEmpty(t, response.Offers, locale)
+				assert.Equal(t, want, response.Offers[0].Download, locale)
+			}
+		})
+	}
+}
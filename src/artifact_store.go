@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var ErrArtifactNotFound = errors.New("artifact not found")
+
+// Artifact identifies a release zip
+type Artifact struct {
+	// Type is "core", "plugin" or "theme", or "blob" for the content
+	// addressed blobs of a ContentStore
+	Type string
+	// Slug is the plugin or theme slug, empty for core
+	Slug string
+	// Version is the release version, or the SHA-256 digest of a blob
+	Version string
+	// Locale is the locale of a localized core build, empty for en_US
+	Locale string
+}
+
+// coreLocale matches the locale suffix of localized core builds, e.g. de_DE
+// or de_CH_informal
+var coreLocale = regexp.MustCompile(`^[a-z]{2,3}(_[A-Z]{2})?(_[a-z]+)?$`)
+
+// sha256Hex matches a hex-encoded SHA-256 digest
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// blobArtifact returns the blob storing the content with the given digest
+func blobArtifact(digest string) Artifact {
+	return Artifact{Type: "blob", Version: digest}
+}
+
+// coreArtifact returns the core artifact of a download name, "6.4.2" or
+// "6.4.2-de_DE"
+func coreArtifact(name string) Artifact {
+	a := Artifact{Type: "core", Version: name}
+	if i := strings.LastIndex(name, "-"); i > 0 && coreLocale.MatchString(name[i+1:]) {
+		a.Version, a.Locale = name[:i], name[i+1:]
+	}
+	return a
+}
+
+// newArtifact returns the artifact a download item fetches
+func newArtifact(item DownloadItem) Artifact {
+	a := Artifact{Type: item.Type, Version: item.Version, Locale: item.Locale}
+	switch item.Type {
+	case "core":
+		if a.Locale == "en_US" {
+			a.Locale = ""
+		}
+	case "plugin":
+		a.Slug = pluginSlug(item.Slug)
+	default:
+		a.Slug = item.Slug
+	}
+	return a
+}
+
+// FileName returns the WordPress.org file name of the artifact:
+// wordpress-<version>[-<locale>].zip for core, <slug>.<version>.zip for
+// plugins and themes. Blobs are named <first two hex digits>/<digest>.
+func (a Artifact) FileName() string {
+	if a.Type == "blob" {
+		return a.Version[:min(2, len(a.Version))] + "/" + a.Version
+	}
+	if a.Type == "core" {
+		if a.Locale != "" {
+			return fmt.Sprintf("wordpress-%s-%s.zip", a.Version, a.Locale)
+		}
+		return fmt.Sprintf("wordpress-%s.zip", a.Version)
+	}
+	return fmt.Sprintf("%s.%s.zip", a.Slug, a.Version)
+}
+
+func (a Artifact) String() string {
+	return a.Type + "/" + a.FileName()
+}
+
+// validate rejects artifacts whose names could escape their directory
+func (a Artifact) validate() error {
+	switch a.Type {
+	case "core", "plugin", "theme":
+	case "blob":
+		if !sha256Hex.MatchString(a.Version) || a.Slug != "" || a.Locale != "" {
+			return fmt.Errorf("invalid blob digest %q", a.Version)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown artifact type %q", a.Type)
+	}
+	for _, part := range []string{a.Slug, a.Version, a.Locale} {
+		if strings.ContainsAny(part, `/\`) || strings.HasPrefix(part, ".") {
+			return fmt.Errorf("invalid artifact name %q", a.FileName())
+		}
+	}
+	if a.Version == "" || (a.Type != "core" && a.Slug == "") {
+		return fmt.Errorf("incomplete artifact %q", a.FileName())
+	}
+	return nil
+}
+
+// ArtifactInfo describes a stored artifact
+type ArtifactInfo struct {
+	Size    int64
+	ModTime time.Time
+	// Digest is the SHA-256 digest of the content if the store knows it
+	Digest string
+}
+
+// ArtifactWriter receives the content of an artifact. Nothing is visible
+// under the artifact's name until Commit; Abort discards the content.
+type ArtifactWriter interface {
+	io.Writer
+	Commit() error
+	Abort() error
+}
+
+// ArtifactStore owns the naming and storage of the release zips. It is the
+// only way the checker, the workers and the server locate or write one.
+type ArtifactStore interface {
+	// Stat returns the info of a stored artifact, or ErrArtifactNotFound
+	Stat(a Artifact) (ArtifactInfo, error)
+	// Open returns the content of a stored artifact, or ErrArtifactNotFound
+	Open(a Artifact) (io.ReadCloser, ArtifactInfo, error)
+	// Create returns a writer storing an artifact, replacing any earlier
+	// copy on Commit
+	Create(a Artifact) (ArtifactWriter, error)
+	// Delete removes a stored artifact
+	Delete(a Artifact) error
+	// Versions lists the stored versions of a plugin or theme slug
+	Versions(kind, slug string) ([]string, error)
+}
+
+// FileArtifactStore stores artifacts in the core, plugins, themes and blobs
+// directories of the paths configuration
+type FileArtifactStore struct {
+	dirs map[string]string
+}
+
+func NewFileArtifactStore(paths PathsConfig) *FileArtifactStore {
+	return &FileArtifactStore{dirs: map[string]string{
+		"core":   paths.CoreDir,
+		"plugin": paths.PluginsDir,
+		"theme":  paths.ThemesDir,
+		"blob":   paths.BlobsDir,
+	}}
+}
+
+// path returns the file of an artifact
+func (s *FileArtifactStore) path(a Artifact) (string, error) {
+	if err := a.validate(); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dirs[a.Type], a.FileName()), nil
+}
+
+// Stat stats the artifact's file
+func (s *FileArtifactStore) Stat(a Artifact) (ArtifactInfo, error) {
+	path, err := s.path(a)
+	if err != nil {
+		return ArtifactInfo{}, err
+	}
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return ArtifactInfo{}, ErrArtifactNotFound
+	}
+	if err != nil {
+		return ArtifactInfo{}, err
+	}
+	return ArtifactInfo{Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// Open opens the artifact's file, which is an io.ReadSeeker
+func (s *FileArtifactStore) Open(a Artifact) (io.ReadCloser, ArtifactInfo, error) {
+	path, err := s.path(a)
+	if err != nil {
+		return nil, ArtifactInfo{}, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ArtifactInfo{}, ErrArtifactNotFound
+	}
+	if err != nil {
+		return nil, ArtifactInfo{}, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, ArtifactInfo{}, err
+	}
+	return f, ArtifactInfo{Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// Create writes the artifact to a hidden temporary file in its directory,
+// renamed to the artifact's file on Commit
+func (s *FileArtifactStore) Create(a Artifact) (ArtifactWriter, error) {
+	path, err := s.path(a)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating directory: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.part")
+	if err != nil {
+		return nil, fmt.Errorf("error creating file: %w", err)
+	}
+	return &fileArtifactWriter{File: f, path: path}, nil
+}
+
+// Delete removes the artifact's file
+func (s *FileArtifactStore) Delete(a Artifact) error {
+	path, err := s.path(a)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Versions lists the versions of the <slug>.<version>.zip files of a slug
+func (s *FileArtifactStore) Versions(kind, slug string) ([]string, error) {
+	if err := (Artifact{Type: kind, Slug: slug, Version: "0"}).validate(); err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(filepath.Join(s.dirs[kind], slug+".*.zip"))
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]string, 0, len(matches))
+	for _, path := range matches {
+		versions = append(versions, strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), slug+"."), ".zip"))
+	}
+	return versions, nil
+}
+
+// Partials lists the temporary files of artifacts that were never
+// committed or aborted
+func (s *FileArtifactStore) Partials() ([]string, error) {
+	var partials []string
+	for kind, dir := range s.dirs {
+		if dir == "" {
+			continue
+		}
+		pattern := filepath.Join(dir, ".*.part")
+		if kind == "blob" {
+			pattern = filepath.Join(dir, "*", ".*.part")
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		partials = append(partials, matches...)
+	}
+	return partials, nil
+}
+
+type fileArtifactWriter struct {
+	*os.File
+	path string
+}
+
+// Commit syncs the temporary file before renaming it to the artifact, and
+// the directory after, so a crash cannot leave an empty or missing artifact
+// behind a committed one
+func (w *fileArtifactWriter) Commit() error {
+	if err := w.File.Sync(); err != nil {
+		w.Abort()
+		return fmt.Errorf("error syncing file: %w", err)
+	}
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.Name())
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	if err := os.Rename(w.Name(), w.path); err != nil {
+		os.Remove(w.Name())
+		return fmt.Errorf("error storing file: %w", err)
+	}
+	if err := syncDir(filepath.Dir(w.path)); err != nil {
+		return fmt.Errorf("error syncing directory: %w", err)
+	}
+	return nil
+}
+
+// syncDir flushes the entries of a directory, such as a rename into it
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (w *fileArtifactWriter) Abort() error {
+	w.File.Close()
+	return os.Remove(w.Name())
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifactNames(t *testing.T) {
+	tests := []struct {
+		item DownloadItem
+		want string
+	}{
+		{DownloadItem{Type: "core", Version: "6.4.2"}, "core/wordpress-6.4.2.zip"},
+		{DownloadItem{Type: "core", Version: "6.4.2", Locale: "en_US"}, "core/wordpress-6.4.2.zip"},
+		{DownloadItem{Type: "core", Version: "6.4.2", Locale: "de_DE"}, "core/wordpress-6.4.2-de_DE.zip"},
+		{DownloadItem{Type: "plugin", Slug: "akismet/akismet.php", Version: "5.3"}, "plugin/akismet.5.3.zip"},
+		{DownloadItem{Type: "plugin", Slug: "hello.php", Version: "1.7.2"}, "plugin/hello.1.7.2.zip"},
+		{DownloadItem{Type: "theme", Slug: "twentytwentyfour", Version: "1.0"}, "theme/twentytwentyfour.1.0.zip"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, newArtifact(tt.item).String())
+	}
+
+	assert.Equal(t, Artifact{Type: "core", Version: "6.4.2"}, coreArtifact("6.4.2"))
+	assert.Equal(t, Artifact{Type: "core", Version: "6.4.2", Locale: "de_CH_informal"}, coreArtifact("6.4.2-de_CH_informal"))
+	assert.Equal(t, Artifact{Type: "core", Version: "6.5-RC1"}, coreArtifact("6.5-RC1"))
+
+	assert.Error(t, Artifact{Type: "plugin", Slug: "..", Version: "1.0"}.validate())
+	assert.Error(t, Artifact{Type: "theme", Slug: "a/b", Version: "1.0"}.validate())
+	assert.Error(t, Artifact{Type: "plugin", Version: "1.0"}.validate())
+	assert.Error(t, Artifact{Type: "other", Slug: "a", Version: "1.0"}.validate())
+}
+
+func TestFileArtifactStore(t *testing.T) {
+	dir := t.TempDir()
+	artifacts := NewFileArtifactStore(PathsConfig{PluginsDir: filepath.Join(dir, "plugins")})
+	a := Artifact{Type: "plugin", Slug: "akismet", Version: "5.3"}
+
+	_, err := artifacts.Stat(a)
+	assert.ErrorIs(t, err, ErrArtifactNotFound)
+
+	// Aborted writes leave nothing behind
+	w, err := artifacts.Create(a)
+	require.NoError(t, err)
+	w.Write([]byte("partial"))
+	require.NoError(t, w.Abort())
+	entries, err := os.ReadDir(filepath.Join(dir, "plugins"))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	w, err = artifacts.Create(a)
+	require.NoError(t, err)
+	w.Write([]byte("akismet 5.3"))
+	_, err = artifacts.Stat(a)
+	assert.ErrorIs(t, err, ErrArtifactNotFound)
+	require.NoError(t, w.Commit())
+
+	r, info, err := artifacts.Open(a)
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "akismet 5.3", string(content))
+	assert.Equal(t, int64(len(content)), info.Size)
+
+	versions, err := artifacts.Versions("plugin", "akismet")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5.3"}, versions)
+
+	require.NoError(t, artifacts.Delete(a))
+	_, err = artifacts.Stat(a)
+	assert.ErrorIs(t, err, ErrArtifactNotFound)
+	assert.NoError(t, artifacts.Delete(a))
+}
+
+func TestWorkerCheckerAndServerAgreeOnNames(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testZip(t, r.URL.Path))
+	}))
+	defer upstream.Close()
+	setupProxyConfig(t, "http://127.0.0.1:1")
+	cfg.Proxy.Enabled = false
+	cfg.Worker.VerifyCore = false
+
+	store := NewMemoryStore()
+	require.NoError(t, store.SetCoreVersions([]CoreVersion{
+		{Version: "6.4.2", Locale: "de_DE", Package: upstream.URL + "/release/de_DE/wordpress-6.4.2.zip"},
+	}))
+	require.NoError(t, store.SetPluginVersions("akismet/akismet.php", []PluginVersion{
+		{Slug: "akismet", NewVersion: "5.3", Package: upstream.URL + "/plugin/akismet.5.3.zip"},
+	}))
+	artifacts := NewFileArtifactStore(cfg.Paths)
+	checker := NewDownloadChecker(store, artifacts)
+	workers := NewDownloadWorkers(store, artifacts)
+
+	require.NoError(t, checker.checkAndQueueDownloads())
+	require.Len(t, store.queue, 2)
+	for len(store.queue) > 0 {
+		item, err := store.PopDownload()
+		require.NoError(t, err)
+		require.NoError(t, workers.downloadFile(item))
+	}
+
+	// Everything the workers stored is seen by the checker and served
+	require.NoError(t, checker.checkAndQueueDownloads())
+	assert.Empty(t, store.queue)
+
+	router := NewServer(store, artifacts).setupRouter()
+	for path, want := range map[string]string{
+		"/core/6.4.2-de_DE.zip":    "/release/de_DE/wordpress-6.4.2.zip",
+		"/plugins/akismet/5.3.zip": "/plugin/akismet.5.3.zip",
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code, path)
+		assert.Equal(t, testZip(t, want), w.Body.Bytes(), path)
+	}
+}
@@ -0,0 +1,952 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket names of the bolt schema. Plugin and theme versions live in one
+// nested bucket per plugin file or theme slug, keyed by version, mirroring
+// the plugins:<file> and themes:<slug> Redis hashes.
+var (
+	boltMetaBucket          = []byte("meta")
+	boltCoreVersionsBucket  = []byte("core_versions")
+	boltPluginsBucket       = []byte("plugins")
+	boltThemesBucket        = []byte("themes")
+	boltDownloadQueueBucket = []byte("download_queue")
+	boltLocksBucket         = []byte("locks")
+	boltPluginInfoBucket    = []byte("plugin_info")
+	boltPluginSummaryBucket = []byte("plugin_summaries")
+	boltThemeInfoBucket     = []byte("theme_info")
+	boltThemeSummaryBucket  = []byte("theme_summaries")
+	boltCrawlCursorsBucket  = []byte("crawl_cursors")
+	boltDemandsBucket       = []byte("demands")
+	boltRollbacksBucket     = []byte("rollbacks")
+	boltArtifactsBucket     = []byte("artifact_digests")
+	boltBlobRefsBucket      = []byte("blob_refs")
+	boltDownloadStateBucket = []byte("download_states")
+
+	boltSchemaVersionKey = []byte("schema_version")
+)
+
+// boltMigration upgrades the bolt schema from version-1 to version
+type boltMigration struct {
+	version int
+	name    string
+	up      func(tx *bolt.Tx) error
+}
+
+// boltMigrations lists every schema change in order. Append new migrations
+// to the end; never edit one that has been released.
+var boltMigrations = []boltMigration{
+	{
+		version: 1,
+		name:    "create core, plugin, theme, queue and lock buckets",
+		up: func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{
+				boltCoreVersionsBucket,
+				boltPluginsBucket,
+				boltThemesBucket,
+				boltDownloadQueueBucket,
+				boltLocksBucket,
+			} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 2,
+		name:    "create plugin information buckets",
+		up: func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{
+				boltPluginInfoBucket,
+				boltPluginSummaryBucket,
+			} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 3,
+		name:    "create theme information buckets",
+		up: func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{
+				boltThemeInfoBucket,
+				boltThemeSummaryBucket,
+			} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 4,
+		name:    "create crawl cursor bucket",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(boltCrawlCursorsBucket)
+			return err
+		},
+	},
+	{
+		version: 5,
+		name:    "create demanded slugs bucket",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(boltDemandsBucket)
+			return err
+		},
+	},
+	{
+		version: 6,
+		name:    "create rollbacks bucket",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(boltRollbacksBucket)
+			return err
+		},
+	},
+	{
+		version: 7,
+		name:    "create artifact digest and blob reference buckets",
+		up: func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{
+				boltArtifactsBucket,
+				boltBlobRefsBucket,
+			} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 8,
+		name:    "rebuild plugin summaries with their contributors",
+		up: func(tx *bolt.Tx) error {
+			summaries := tx.Bucket(boltPluginSummaryBucket)
+			return tx.Bucket(boltPluginInfoBucket).ForEach(func(k, v []byte) error {
+				var info PluginInfo
+				if err := json.Unmarshal(v, &info); err != nil {
+					return fmt.Errorf("error decoding plugin %s: %w", k, err)
+				}
+				return putJSON(summaries, string(k), info.Summary())
+			})
+		},
+	},
+	{
+		version: 9,
+		name:    "key core versions by version and locale",
+		up: func(tx *bolt.Tx) error {
+			b := tx.Bucket(boltCoreVersionsBucket)
+			var versions []CoreVersion
+			var keys [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				var version CoreVersion
+				if err := json.Unmarshal(v, &version); err != nil {
+					return fmt.Errorf("error decoding core version %s: %w", k, err)
+				}
+				versions = append(versions, version)
+				keys = append(keys, append([]byte{}, k...))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			for _, version := range versions {
+				if err := putJSON(b, version.key(), version); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 10,
+		name:    "create download state bucket",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(boltDownloadStateBucket)
+			return err
+		},
+	},
+}
+
+// latestBoltSchemaVersion is the schema version this build expects
+func latestBoltSchemaVersion() int {
+	return boltMigrations[len(boltMigrations)-1].version
+}
+
+// BoltStore is a durable MetadataStore kept in a single bbolt file. The file
+// is locked by the process that opens it, so all components using it must
+// run in that process (e.g. "wp-mirror all").
+type BoltStore struct {
+	db *bolt.DB
+
+	// pushes counts queue pushes so PopDownload can wait for new items
+	mu     sync.Mutex
+	cond   *sync.Cond
+	pushes uint64
+}
+
+// openBoltDB opens the bolt file, creating it if needed
+func openBoltDB(path string) (*bolt.DB, error) {
+	return bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+}
+
+// OpenBoltStore opens the bolt file at path. It fails if the schema is not
+// at the version this build expects; run "wp-mirror migrate" first.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := openBoltDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var current int
+	err = db.View(func(tx *bolt.Tx) error {
+		current, err = boltSchemaVersion(tx)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if latest := latestBoltSchemaVersion(); current != latest {
+		db.Close()
+		return nil, fmt.Errorf("schema version is %d, expected %d: run \"wp-mirror migrate\"", current, latest)
+	}
+
+	s := &BoltStore{db: db}
+	s.cond = sync.NewCond(&s.mu)
+	return s, nil
+}
+
+// MigrateBolt applies all pending migrations to the bolt file at path and
+// returns the schema versions before and after
+func MigrateBolt(path string) (from, to int, err error) {
+	db, err := openBoltDB(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		from, err = boltSchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		if from > latestBoltSchemaVersion() {
+			return fmt.Errorf("schema version %d is newer than this build supports (%d)", from, latestBoltSchemaVersion())
+		}
+
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+		to = from
+		for _, m := range boltMigrations {
+			if m.version <= from {
+				continue
+			}
+			log.Printf("Applying bolt migration %d: %s", m.version, m.name)
+			if err := m.up(tx); err != nil {
+				return fmt.Errorf("migration %d failed: %w", m.version, err)
+			}
+			to = m.version
+		}
+		return meta.Put(boltSchemaVersionKey, []byte(strconv.Itoa(to)))
+	})
+	return from, to, err
+}
+
+// boltSchemaVersion reads the schema version, 0 for a fresh file
+func boltSchemaVersion(tx *bolt.Tx) (int, error) {
+	meta := tx.Bucket(boltMetaBucket)
+	if meta == nil {
+		return 0, nil
+	}
+	data := meta.Get(boltSchemaVersionKey)
+	if data == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(string(data))
+}
+
+// Close closes the underlying bolt file
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// putJSON stores v as JSON under key in bucket
+func putJSON(b *bolt.Bucket, key string, v interface{}) error {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), jsonData)
+}
+
+// SetCoreVersions sets the list of core version information
+func (s *BoltStore) SetCoreVersions(versions []CoreVersion) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltCoreVersionsBucket)
+		for _, v := range versions {
+			if err := putJSON(b, v.key(), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetCoreVersions gets the list of core version information
+func (s *BoltStore) GetCoreVersions() ([]CoreVersion, error) {
+	var versions []CoreVersion
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltCoreVersionsBucket).ForEach(func(_, data []byte) error {
+			var version CoreVersion
+			if err := json.Unmarshal(data, &version); err != nil {
+				return err
+			}
+			versions = append(versions, version)
+			return nil
+		})
+	})
+	return versions, err
+}
+
+// SetPluginVersions sets the list of plugin version information for a given plugin file
+func (s *BoltStore) SetPluginVersions(pluginFile string, versions []PluginVersion) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(boltPluginsBucket).CreateBucketIfNotExists([]byte(pluginFile))
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			if err := putJSON(b, v.NewVersion, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetPluginVersions gets the list of plugin version information for a given plugin file
+func (s *BoltStore) GetPluginVersions(pluginFile string) ([]PluginVersion, error) {
+	var versions []PluginVersion
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltPluginsBucket).Bucket([]byte(pluginFile))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, data []byte) error {
+			var version PluginVersion
+			if err := json.Unmarshal(data, &version); err != nil {
+				return err
+			}
+			versions = append(versions, version)
+			return nil
+		})
+	})
+	return versions, err
+}
+
+// DeletePluginVersions removes versions of a plugin file, dropping its
+// bucket once it has none left
+func (s *BoltStore) DeletePluginVersions(pluginFile string, versions []string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteNestedKeys(tx.Bucket(boltPluginsBucket), pluginFile, versions)
+	})
+}
+
+// GetLatestPluginVersion gets the latest plugin version information for a given plugin file
+func (s *BoltStore) GetLatestPluginVersion(pluginFile string) (*PluginVersion, error) {
+	var latestVersion PluginVersion
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltPluginsBucket).Bucket([]byte(pluginFile))
+		if b == nil {
+			return ErrNoPluginVersions
+		}
+		var latestVersionNumber string
+		err := b.ForEach(func(version, data []byte) error {
+			if CompareVersions(string(version), latestVersionNumber) > 0 {
+				latestVersionNumber = string(version)
+				return json.Unmarshal(data, &latestVersion)
+			}
+			return nil
+		})
+		if err == nil && latestVersionNumber == "" {
+			return ErrNoPluginVersions
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &latestVersion, nil
+}
+
+// ListAllPluginFiles lists all stored plugin files
+func (s *BoltStore) ListAllPluginFiles() ([]string, error) {
+	return s.listNestedBuckets(boltPluginsBucket)
+}
+
+// SetPluginInfo stores the full record and its summary in separate buckets,
+// so queries need not load the sections of every plugin
+func (s *BoltStore) SetPluginInfo(info PluginInfo) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := putJSON(tx.Bucket(boltPluginInfoBucket), info.Slug, info); err != nil {
+			return err
+		}
+		return putJSON(tx.Bucket(boltPluginSummaryBucket), info.Slug, info.Summary())
+	})
+}
+
+// GetPluginInfo gets the full plugin record for a given slug
+func (s *BoltStore) GetPluginInfo(slug string) (*PluginInfo, error) {
+	var info PluginInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltPluginInfoBucket).Get([]byte(slug))
+		if data == nil {
+			return ErrPluginInfoNotFound
+		}
+		return json.Unmarshal(data, &info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// ListPluginSummaries lists the summaries of all stored plugin records
+func (s *BoltStore) ListPluginSummaries() ([]PluginInfo, error) {
+	summaries := []PluginInfo{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltPluginSummaryBucket).ForEach(func(_, data []byte) error {
+			var info PluginInfo
+			if err := json.Unmarshal(data, &info); err != nil {
+				return err
+			}
+			summaries = append(summaries, info)
+			return nil
+		})
+	})
+	return summaries, err
+}
+
+// SetThemeInfo stores the full record and its summary in separate buckets
+func (s *BoltStore) SetThemeInfo(info ThemeInfo) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := putJSON(tx.Bucket(boltThemeInfoBucket), info.Slug, info); err != nil {
+			return err
+		}
+		return putJSON(tx.Bucket(boltThemeSummaryBucket), info.Slug, info.Summary())
+	})
+}
+
+// GetThemeInfo gets the full theme record for a given slug
+func (s *BoltStore) GetThemeInfo(slug string) (*ThemeInfo, error) {
+	var info ThemeInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltThemeInfoBucket).Get([]byte(slug))
+		if data == nil {
+			return ErrThemeInfoNotFound
+		}
+		return json.Unmarshal(data, &info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// ListThemeSummaries lists the summaries of all stored theme records
+func (s *BoltStore) ListThemeSummaries() ([]ThemeInfo, error) {
+	summaries := []ThemeInfo{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltThemeSummaryBucket).ForEach(func(_, data []byte) error {
+			var info ThemeInfo
+			if err := json.Unmarshal(data, &info); err != nil {
+				return err
+			}
+			summaries = append(summaries, info)
+			return nil
+		})
+	})
+	return summaries, err
+}
+
+// SetThemeVersions sets the list of theme version information for a given theme slug
+func (s *BoltStore) SetThemeVersions(themeSlug string, versions []ThemeVersion) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(boltThemesBucket).CreateBucketIfNotExists([]byte(themeSlug))
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			if err := putJSON(b, v.NewVersion, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetThemeVersions gets the list of theme version information for a given theme slug
+func (s *BoltStore) GetThemeVersions(themeSlug string) ([]ThemeVersion, error) {
+	var versions []ThemeVersion
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltThemesBucket).Bucket([]byte(themeSlug))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, data []byte) error {
+			var version ThemeVersion
+			if err := json.Unmarshal(data, &version); err != nil {
+				return err
+			}
+			versions = append(versions, version)
+			return nil
+		})
+	})
+	return versions, err
+}
+
+// DeleteThemeVersions removes versions of a theme slug, dropping its bucket
+// once it has none left
+func (s *BoltStore) DeleteThemeVersions(themeSlug string, versions []string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteNestedKeys(tx.Bucket(boltThemesBucket), themeSlug, versions)
+	})
+}
+
+// deleteNestedKeys deletes keys from the nested bucket name of parent and
+// the nested bucket itself once it is empty
+func deleteNestedKeys(parent *bolt.Bucket, name string, keys []string) error {
+	b := parent.Bucket([]byte(name))
+	if b == nil {
+		return nil
+	}
+	for _, k := range keys {
+		if err := b.Delete([]byte(k)); err != nil {
+			return err
+		}
+	}
+	if k, _ := b.Cursor().First(); k == nil {
+		return parent.DeleteBucket([]byte(name))
+	}
+	return nil
+}
+
+// GetLatestThemeVersion gets the latest theme version information for a given theme slug
+func (s *BoltStore) GetLatestThemeVersion(themeSlug string) (*ThemeVersion, error) {
+	var latestVersion ThemeVersion
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltThemesBucket).Bucket([]byte(themeSlug))
+		if b == nil {
+			return ErrNoThemeVersions
+		}
+		var latestVersionNumber string
+		err := b.ForEach(func(version, data []byte) error {
+			if CompareVersions(string(version), latestVersionNumber) > 0 {
+				latestVersionNumber = string(version)
+				return json.Unmarshal(data, &latestVersion)
+			}
+			return nil
+		})
+		if err == nil && latestVersionNumber == "" {
+			return ErrNoThemeVersions
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &latestVersion, nil
+}
+
+// ListAllThemeSlugs lists all stored theme slugs
+func (s *BoltStore) ListAllThemeSlugs() ([]string, error) {
+	return s.listNestedBuckets(boltThemesBucket)
+}
+
+// listNestedBuckets returns the names of the buckets nested in parent
+func (s *BoltStore) listNestedBuckets(parent []byte) ([]string, error) {
+	names := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(parent).ForEach(func(name, value []byte) error {
+			if value == nil {
+				names = append(names, string(name))
+			}
+			return nil
+		})
+	})
+	return names, err
+}
+
+// PushDownload appends an item to the download queue bucket
+func (s *BoltStore) PushDownload(item DownloadItem) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltDownloadQueueBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		jsonData, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, jsonData)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.pushes++
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	return nil
+}
+
+// PopDownload blocks until an item is available in the download queue bucket
+func (s *BoltStore) PopDownload() (DownloadItem, error) {
+	for {
+		s.mu.Lock()
+		seen := s.pushes
+		s.mu.Unlock()
+
+		item, ok, err := s.popFirst()
+		if err != nil || ok {
+			return item, err
+		}
+
+		s.mu.Lock()
+		for s.pushes == seen {
+			s.cond.Wait()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// SetDownloadState stores the state of an item under its key
+func (s *BoltStore) SetDownloadState(state DownloadState) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(boltDownloadStateBucket), state.Key, state)
+	})
+}
+
+// ListDownloadStates lists the download states, ordered by key
+func (s *BoltStore) ListDownloadStates() ([]DownloadState, error) {
+	states := []DownloadState{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDownloadStateBucket).ForEach(func(_, data []byte) error {
+			var state DownloadState
+			if err := json.Unmarshal(data, &state); err != nil {
+				return err
+			}
+			states = append(states, state)
+			return nil
+		})
+	})
+	return states, err
+}
+
+// DeleteDownloadState removes the state of an item
+func (s *BoltStore) DeleteDownloadState(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDownloadStateBucket).Delete([]byte(key))
+	})
+}
+
+// popFirst removes and returns the oldest queued item, if any
+func (s *BoltStore) popFirst() (DownloadItem, bool, error) {
+	var item DownloadItem
+	var found bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltDownloadQueueBucket).Cursor()
+		key, data := c.First()
+		if key == nil {
+			return nil
+		}
+		found = true
+		if err := json.Unmarshal(data, &item); err != nil {
+			return errors.Join(err, c.Delete())
+		}
+		return c.Delete()
+	})
+	return item, found, err
+}
+
+// GetCrawlCursor gets the named crawl cursor
+func (s *BoltStore) GetCrawlCursor(name string) (*CrawlCursor, error) {
+	var cursor CrawlCursor
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltCrawlCursorsBucket).Get([]byte(name))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &cursor)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// SetCrawlCursor stores the named crawl cursor
+func (s *BoltStore) SetCrawlCursor(name string, cursor CrawlCursor) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(boltCrawlCursorsBucket), name, cursor)
+	})
+}
+
+// RecordDemand records a demanded slug in the nested bucket of its kind
+// unless it already is. Known slugs are looked up in a read transaction
+// first, as clients keep asking about them on every update check and a
+// write transaction syncs the file.
+func (s *BoltStore) RecordDemand(kind, slug string) error {
+	known := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(boltDemandsBucket).Bucket([]byte(kind)); b != nil {
+			known = b.Get([]byte(slug)) != nil
+		}
+		return nil
+	})
+	if err != nil || known {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(boltDemandsBucket).CreateBucketIfNotExists([]byte(kind))
+		if err != nil {
+			return err
+		}
+		if b.Get([]byte(slug)) != nil {
+			return nil
+		}
+		return putJSON(b, slug, Demand{Slug: slug, RequestedAt: time.Now().UTC()})
+	})
+}
+
+// ListDemands lists the demanded slugs of kind
+func (s *BoltStore) ListDemands(kind string) ([]Demand, error) {
+	demands := []Demand{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltDemandsBucket).Bucket([]byte(kind))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, data []byte) error {
+			var d Demand
+			if err := json.Unmarshal(data, &d); err != nil {
+				return err
+			}
+			demands = append(demands, d)
+			return nil
+		})
+	})
+	return demands, err
+}
+
+// SetDemand stores a demand
+func (s *BoltStore) SetDemand(kind string, demand Demand) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(boltDemandsBucket).CreateBucketIfNotExists([]byte(kind))
+		if err != nil {
+			return err
+		}
+		return putJSON(b, demand.Slug, demand)
+	})
+}
+
+// DeleteDemand removes a demanded slug
+func (s *BoltStore) DeleteDemand(kind, slug string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltDemandsBucket).Bucket([]byte(kind))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(slug))
+	})
+}
+
+// SetRollback stores a rollback under <type>:<slug>
+func (s *BoltStore) SetRollback(rollback Rollback) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(boltRollbacksBucket), rollback.key(), rollback)
+	})
+}
+
+// GetRollback gets the rollback of a slug of kind
+func (s *BoltStore) GetRollback(kind, slug string) (*Rollback, error) {
+	var rollback Rollback
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltRollbacksBucket).Get([]byte(rollbackKey(kind, slug)))
+		if data == nil {
+			return ErrRollbackNotFound
+		}
+		return json.Unmarshal(data, &rollback)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rollback, nil
+}
+
+// ListRollbacks lists every rollback, ordered by type and slug
+func (s *BoltStore) ListRollbacks() ([]Rollback, error) {
+	rollbacks := []Rollback{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRollbacksBucket).ForEach(func(_, data []byte) error {
+			var rollback Rollback
+			if err := json.Unmarshal(data, &rollback); err != nil {
+				return err
+			}
+			rollbacks = append(rollbacks, rollback)
+			return nil
+		})
+	})
+	return rollbacks, err
+}
+
+// DeleteRollback removes the rollback of a slug of kind
+func (s *BoltStore) DeleteRollback(kind, slug string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRollbacksBucket).Delete([]byte(rollbackKey(kind, slug)))
+	})
+}
+
+// SetArtifactDigest maps an artifact key to a digest and records the key in
+// the nested reference bucket of the digest
+func (s *BoltStore) SetArtifactDigest(key, digest string) (string, error) {
+	var orphan string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		digests := tx.Bucket(boltArtifactsBucket)
+		old := string(digests.Get([]byte(key)))
+		if err := digests.Put([]byte(key), []byte(digest)); err != nil {
+			return err
+		}
+		refs, err := tx.Bucket(boltBlobRefsBucket).CreateBucketIfNotExists([]byte(digest))
+		if err != nil {
+			return err
+		}
+		if err := refs.Put([]byte(key), []byte{}); err != nil {
+			return err
+		}
+		if old != "" && old != digest {
+			orphan, err = unreferenceBlob(tx, old, key)
+		}
+		return err
+	})
+	return orphan, err
+}
+
+// GetArtifactDigest gets the digest of an artifact key
+func (s *BoltStore) GetArtifactDigest(key string) (string, error) {
+	var digest string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltArtifactsBucket).Get([]byte(key))
+		if data == nil {
+			return ErrArtifactNotFound
+		}
+		digest = string(data)
+		return nil
+	})
+	return digest, err
+}
+
+// ListArtifactDigests seeks to prefix in the ordered digest bucket
+func (s *BoltStore) ListArtifactDigests(prefix string) (map[string]string, error) {
+	digests := make(map[string]string)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltArtifactsBucket).Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			digests[string(k)] = string(v)
+		}
+		return nil
+	})
+	return digests, err
+}
+
+// DeleteArtifactDigest removes the mapping of an artifact key
+func (s *BoltStore) DeleteArtifactDigest(key string) (string, error) {
+	var orphan string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		digests := tx.Bucket(boltArtifactsBucket)
+		digest := digests.Get([]byte(key))
+		if digest == nil {
+			return nil
+		}
+		old := string(digest)
+		if err := digests.Delete([]byte(key)); err != nil {
+			return err
+		}
+		var err error
+		orphan, err = unreferenceBlob(tx, old, key)
+		return err
+	})
+	return orphan, err
+}
+
+// unreferenceBlob drops key from the references of digest, removing the
+// reference bucket and returning digest if that was the last one
+func unreferenceBlob(tx *bolt.Tx, digest, key string) (string, error) {
+	refs := tx.Bucket(boltBlobRefsBucket)
+	b := refs.Bucket([]byte(digest))
+	if b == nil {
+		return digest, nil
+	}
+	if err := b.Delete([]byte(key)); err != nil {
+		return "", err
+	}
+	if k, _ := b.Cursor().First(); k != nil {
+		return "", nil
+	}
+	return digest, refs.DeleteBucket([]byte(digest))
+}
+
+// AcquireLock takes the lock unless another holder's lock has not expired
+func (s *BoltStore) AcquireLock(key string, ttl time.Duration) (bool, error) {
+	var acquired bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltLocksBucket)
+		now := time.Now()
+		if data := b.Get([]byte(key)); data != nil {
+			expiry, err := strconv.ParseInt(string(data), 10, 64)
+			if err == nil && now.Before(time.Unix(0, expiry)) {
+				return nil
+			}
+		}
+		acquired = true
+		return b.Put([]byte(key), []byte(strconv.FormatInt(now.Add(ttl).UnixNano(), 10)))
+	})
+	return acquired, err
+}
+
+// ReleaseLock frees the lock
+func (s *BoltStore) ReleaseLock(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltLocksBucket).Delete([]byte(key))
+	})
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltStoreRequiresMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wp-mirror.db")
+
+	_, err := OpenBoltStore(path)
+	assert.Error(t, err)
+
+	from, to, err := MigrateBolt(path)
+	require.NoError(t, err)
+	assert.Equal(t, 0, from)
+	assert.Equal(t, latestBoltSchemaVersion(), to)
+
+	from, to, err = MigrateBolt(path)
+	require.NoError(t, err)
+	assert.Equal(t, to, from)
+
+	store, err := OpenBoltStore(path)
+	require.NoError(t, err)
+	assert.NoError(t, store.Close())
+}
+
+// openTestBoltStore returns a migrated bolt store in a temporary file
+func openTestBoltStore(t *testing.T) *BoltStore {
+	path := filepath.Join(t.TempDir(), "wp-mirror.db")
+	_, _, err := MigrateBolt(path)
+	require.NoError(t, err)
+	store, err := OpenBoltStore(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestBoltStoreRoundTrip(t *testing.T) {
+	store := openTestBoltStore(t)
+
+	seedDummyData(store)
+
+	files, err := store.ListAllPluginFiles()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"akismet/akismet.php", "contact-form-7/wp-contact-form-7.php"}, files)
+
+	latest, err := store.GetLatestThemeVersion("twentytwentythree")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", latest.NewVersion)
+
+	_, err = store.GetLatestPluginVersion("missing/missing.php")
+	assert.ErrorIs(t, err, ErrNoPluginVersions)
+
+	require.NoError(t, store.PushDownload(DownloadItem{Type: "core", Version: "6.2.1"}))
+	require.NoError(t, store.PushDownload(DownloadItem{Type: "core", Version: "6.1.3"}))
+	item, err := store.PopDownload()
+	require.NoError(t, err)
+	assert.Equal(t, "6.2.1", item.Version)
+	item, err = store.PopDownload()
+	require.NoError(t, err)
+	assert.Equal(t, "6.1.3", item.Version)
+}
+
+func TestBoltStoreDemands(t *testing.T) {
+	store := openTestBoltStore(t)
+
+	require.NoError(t, store.RecordDemand("plugin", "commercial-plugin"))
+	stats := store.db.Stats()
+
+	// Clients asking about a known slug again do not write to the file
+	for i := 0; i < 3; i++ {
+		require.NoError(t, store.RecordDemand("plugin", "commercial-plugin"))
+	}
+	after := store.db.Stats()
+	diff := after.Sub(&stats)
+	assert.Zero(t, diff.TxStats.GetWrite())
+	demands, err := store.ListDemands("plugin")
+	require.NoError(t, err)
+	assert.Len(t, demands, 1)
+
+	require.NoError(t, store.RecordDemand("theme", "new-theme"))
+	demands, err = store.ListDemands("theme")
+	require.NoError(t, err)
+	require.Len(t, demands, 1)
+	demands[0].Unavailable = true
+	require.NoError(t, store.SetDemand("theme", demands[0]))
+	require.NoError(t, store.RecordDemand("theme", "new-theme"))
+	demands, err = store.ListDemands("theme")
+	require.NoError(t, err)
+	assert.True(t, demands[0].Unavailable)
+	require.NoError(t, store.DeleteDemand("theme", "new-theme"))
+	demands, err = store.ListDemands("theme")
+	require.NoError(t, err)
+	assert.Empty(t, demands)
+}
+
+func TestBoltStoreRollbacks(t *testing.T) {
+	store := openTestBoltStore(t)
+
+	require.NoError(t, store.SetRollback(Rollback{Type: "plugin", Slug: "akismet", Version: "5.0"}))
+	rollback, err := store.GetRollback("plugin", "akismet")
+	require.NoError(t, err)
+	assert.Equal(t, "5.0", rollback.Version)
+	require.NoError(t, store.DeleteRollback("plugin", "akismet"))
+	_, err = store.GetRollback("plugin", "akismet")
+	assert.ErrorIs(t, err, ErrRollbackNotFound)
+}
+
+func TestBoltStoreArtifactDigests(t *testing.T) {
+	store := openTestBoltStore(t)
+
+	digest := strings.Repeat("ab", 32)
+	orphan, err := store.SetArtifactDigest("plugin/akismet.5.0.zip", digest)
+	require.NoError(t, err)
+	assert.Empty(t, orphan)
+	_, err = store.SetArtifactDigest("plugin/akismet.5.0.1.zip", digest)
+	require.NoError(t, err)
+	_, err = store.SetArtifactDigest("theme/akismet.1.0.zip", digest)
+	require.NoError(t, err)
+	digests, err := store.ListArtifactDigests("plugin/akismet.")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"plugin/akismet.5.0.zip": digest, "plugin/akismet.5.0.1.zip": digest}, digests)
+	for _, key := range []string{"plugin/akismet.5.0.zip", "plugin/akismet.5.0.1.zip"} {
+		orphan, err = store.DeleteArtifactDigest(key)
+		require.NoError(t, err)
+		assert.Empty(t, orphan)
+	}
+	orphan, err = store.SetArtifactDigest("theme/akismet.1.0.zip", strings.Repeat("cd", 32))
+	require.NoError(t, err)
+	assert.Equal(t, digest, orphan)
+	_, err = store.GetArtifactDigest("plugin/akismet.5.0.zip")
+	assert.ErrorIs(t, err, ErrArtifactNotFound)
+}
+
+func TestBoltMigrationRebuildsPluginSummaries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wp-mirror.db")
+	_, _, err := MigrateBolt(path)
+	require.NoError(t, err)
+
+	// A version 7 file, whose summaries were stored without contributors
+	db, err := openBoltDB(path)
+	require.NoError(t, err)
+	info := PluginInfo{Slug: "akismet", AuthorProfile: "https://profiles.wordpress.org/automattic/",
+		Contributors: json.RawMessage(`{"kbrown9":{"profile":"https://profiles.wordpress.org/kbrown9/"}}`)}
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		if err := putJSON(tx.Bucket(boltPluginInfoBucket), info.Slug, info); err != nil {
+			return err
+		}
+		old := info.Summary()
+		old.Contributors = nil
+		if err := putJSON(tx.Bucket(boltPluginSummaryBucket), info.Slug, old); err != nil {
+			return err
+		}
+		return tx.Bucket(boltMetaBucket).Put(boltSchemaVersionKey, []byte("7"))
+	}))
+	require.NoError(t, db.Close())
+
+	from, _, err := MigrateBolt(path)
+	require.NoError(t, err)
+	assert.Equal(t, 7, from)
+	store, err := OpenBoltStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+	summaries, err := store.ListPluginSummaries()
+	require.NoError(t, err)
+	require.Len(t, summaries, 1)
+	assert.True(t, hasContributor(summaries[0].Contributors, "kbrown9"))
+}
+
+func TestBoltMigrationKeysCoreVersionsByLocale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wp-mirror.db")
+	_, _, err := MigrateBolt(path)
+	require.NoError(t, err)
+
+	// A version 8 file, whose core versions were keyed by version alone
+	db, err := openBoltDB(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltCoreVersionsBucket)
+		if err := putJSON(b, "6.4.2", CoreVersion{Version: "6.4.2", Locale: "de_DE"}); err != nil {
+			return err
+		}
+		return tx.Bucket(boltMetaBucket).Put(boltSchemaVersionKey, []byte("8"))
+	}))
+	require.NoError(t, db.Close())
+
+	from, _, err := MigrateBolt(path)
+	require.NoError(t, err)
+	assert.Equal(t, 8, from)
+	store, err := OpenBoltStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	// The en_US build no longer replaces the de_DE one
+	require.NoError(t, store.SetCoreVersions([]CoreVersion{{Version: "6.4.2", Locale: "en_US"}}))
+	versions, err := store.GetCoreVersions()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []CoreVersion{{Version: "6.4.2", Locale: "de_DE"}, {Version: "6.4.2", Locale: "en_US"}}, versions)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Updater sync modes. The crawl mode finds changes by walking the
+// directory sorted by last update; the changelog mode reads the SVN
+// revisions committed since the last sync from the directory's Trac.
+const (
+	SyncCrawl     = "crawl"
+	SyncChangelog = "changelog"
+)
+
+// errNotInDirectory is returned for slugs the upstream directory does not
+// serve, e.g. closed plugins
+var errNotInDirectory = errors.New("not in the upstream directory")
+
+// changelogEntry is one revision of a Trac changelog with the paths it
+// touched
+type changelogEntry struct {
+	Revision int
+	Paths    []string
+}
+
+var (
+	changelogRevisionLine = regexp.MustCompile(`^\s*\[(\d+)\]\s*$`)
+	changelogPathLine     = regexp.MustCompile(`^\s*\* (\S+)`)
+)
+
+// parseChangelog reads Trac's format=changelog output, newest revision
+// first:
+//
+//	2024-01-10  author
+//
+//		[3024567]
+//		* akismet/trunk/readme.txt (modified)
+//
+//		Commit message
+//
+// Only the lines directly after a revision are read as paths, so list items
+// in commit messages are not mistaken for them.
+func parseChangelog(r io.Reader) ([]changelogEntry, error) {
+	var entries []changelogEntry
+	inPaths := false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := changelogRevisionLine.FindStringSubmatch(line); m != nil {
+			rev, err := strconv.Atoi(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid revision %q: %w", m[1], err)
+			}
+			entries = append(entries, changelogEntry{Revision: rev})
+			inPaths = true
+			continue
+		}
+		if !inPaths {
+			continue
+		}
+		if m := changelogPathLine.FindStringSubmatch(line); m != nil {
+			last := &entries[len(entries)-1]
+			last.Paths = append(last.Paths, m[1])
+			continue
+		}
+		inPaths = false
+	}
+	return entries, scanner.Err()
+}
+
+// changelogSlugs returns the sorted slugs touched by the entries, the first
+// path component of every changed path
+func changelogSlugs(entries []changelogEntry) []string {
+	seen := make(map[string]bool)
+	var slugs []string
+	for _, e := range entries {
+		for _, p := range e.Paths {
+			slug := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)[0]
+			if slug == "" || seen[slug] {
+				continue
+			}
+			seen[slug] = true
+			slugs = append(slugs, slug)
+		}
+	}
+	sort.Strings(slugs)
+	return slugs
+}
+
+// SyncChangelog re-fetches the records of the slugs committed to dir's SVN
+// repository since the last synced revision. The first run only records the
+// current revision; the entries changed before it come from a crawl.
+func (cr *Crawler) SyncChangelog(dir crawlDirectory) error {
+	name := dir.name + "-changelog"
+	cursor, err := cr.store.GetCrawlCursor(name)
+	if err != nil {
+		return fmt.Errorf("error reading %s cursor: %w", name, err)
+	}
+
+	if cursor.Revision == 0 {
+		head, err := cr.fetchChangelog(dir, 0, 0, 1)
+		if err != nil {
+			return err
+		}
+		if len(head) == 0 {
+			return fmt.Errorf("the %s changelog is empty", dir.name)
+		}
+		log.Printf("Starting %s changelog sync at revision %d; run a full crawl for earlier changes", dir.name, head[0].Revision)
+		return cr.store.SetCrawlCursor(name, CrawlCursor{Revision: head[0].Revision, SyncedAt: time.Now().UTC()})
+	}
+
+	entries, err := cr.changelogSince(dir, cursor.Revision)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		log.Printf("No %s changes since revision %d", dir.name, cursor.Revision)
+		return nil
+	}
+
+	var slugs []string
+	for _, slug := range changelogSlugs(entries) {
+		if dir.scope().Allows(slug) {
+			slugs = append(slugs, slug)
+		}
+	}
+	log.Printf("Syncing %d %s touched in revisions %d to %d", len(slugs), dir.name, cursor.Revision+1, entries[0].Revision)
+	for i, slug := range slugs {
+		if i > 0 {
+			time.Sleep(cfg.Crawler.RequestInterval)
+		}
+		err := cr.syncSlug(dir, slug)
+		if errors.Is(err, errNotInDirectory) {
+			log.Printf("Skipping %s %s: %v", dir.name, slug, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error syncing %s %s: %w", dir.name, slug, err)
+		}
+	}
+
+	synced := CrawlCursor{Revision: entries[0].Revision, SyncedAt: time.Now().UTC()}
+	if err := cr.store.SetCrawlCursor(name, synced); err != nil {
+		return fmt.Errorf("error saving %s cursor: %w", name, err)
+	}
+	log.Printf("Synced %s up to revision %d", dir.name, synced.Revision)
+	return nil
+}
+
+// changelogSince returns the entries after revision since, newest first,
+// paging back through the changelog cfg.Updater.ChangelogLimit revisions
+// at a time
+func (cr *Crawler) changelogSince(dir crawlDirectory, since int) ([]changelogEntry, error) {
+	var entries []changelogEntry
+	rev := 0
+	for {
+		page, err := cr.fetchChangelog(dir, rev, since+1, cfg.Updater.ChangelogLimit)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range page {
+			if e.Revision > since {
+				entries = append(entries, e)
+			}
+		}
+
+		if len(page) < cfg.Updater.ChangelogLimit {
+			return entries, nil
+		}
+		oldest := page[len(page)-1].Revision
+		if oldest <= since+1 {
+			return entries, nil
+		}
+		rev = oldest - 1
+		time.Sleep(cfg.Crawler.RequestInterval)
+	}
+}
+
+// fetchChangelog reads up to limit revisions of dir's changelog from rev
+// (HEAD if 0) back to stopRev (the first revision if 0)
+func (cr *Crawler) fetchChangelog(dir crawlDirectory, rev, stopRev, limit int) ([]changelogEntry, error) {
+	u, err := url.Parse(dir.changelogURL())
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s changelog URL: %w", dir.name, err)
+	}
+	q := u.Query()
+	q.Set("format", "changelog")
+	q.Set("rev", "HEAD")
+	if rev > 0 {
+		q.Set("rev", strconv.Itoa(rev))
+	}
+	if stopRev > 0 {
+		q.Set("stop_rev", strconv.Itoa(stopRev))
+	}
+	q.Set("limit", strconv.Itoa(limit))
+	u.RawQuery = q.Encode()
+
+	body, err := cr.fetch(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s changelog: %w", dir.name, err)
+	}
+	entries, err := parseChangelog(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s changelog: %w", dir.name, err)
+	}
+	return entries, nil
+}
+
+// syncSlug fetches the current record of one slug and stores it
+func (cr *Crawler) syncSlug(dir crawlDirectory, slug string) error {
+	u, err := url.Parse(dir.apiURL())
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("action", dir.infoAction)
+	q.Set("request[slug]", slug)
+	u.RawQuery = q.Encode()
+
+	body, err := cr.fetch(u.String())
+	if err != nil {
+		return err
+	}
+	return dir.storeInfo(cr.store, body)
+}
+
+// storePluginInformation stores a plugin_information response
+func storePluginInformation(store MetadataStore, body []byte) error {
+	if isEmptyResponse(body) {
+		return errNotInDirectory
+	}
+	var info PluginInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return fmt.Errorf("error unmarshalling plugin information: %w", err)
+	}
+	if info.Slug == "" {
+		return errNotInDirectory
+	}
+	return storePluginInfo(store, info)
+}
+
+// storeThemeInformation stores a theme_information response
+func storeThemeInformation(store MetadataStore, body []byte) error {
+	if isEmptyResponse(body) {
+		return errNotInDirectory
+	}
+	var info ThemeInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return fmt.Errorf("error unmarshalling theme information: %w", err)
+	}
+	if info.Slug == "" {
+		return errNotInDirectory
+	}
+	return storeThemeInfo(store, info)
+}
+
+// isEmptyResponse reports whether an information response is false or null,
+// as the themes API answers for unknown slugs
+func isEmptyResponse(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	return bytes.Equal(body, []byte("false")) || bytes.Equal(body, []byte("null"))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChangelog(t *testing.T) {
+	f, err := os.Open("testdata/plugins-changelog.txt")
+	require.NoError(t, err)
+	defer f.Close()
+
+	entries, err := parseChangelog(f)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	assert.Equal(t, 3024570, entries[0].Revision)
+	assert.Equal(t, []string{"contact-form-7/tags/5.8.6", "contact-form-7/trunk/readme.txt"}, entries[0].Paths)
+	assert.Equal(t, []string{"akismet", "closed-plugin", "contact-form-7"}, changelogSlugs(entries))
+}
+
+// fakeTrac serves the recorded changelog, filtered by rev, stop_rev and
+// limit, and the plugin_information of every slug but closed-plugin
+type fakeTrac struct {
+	mu        sync.Mutex
+	changelog []byte
+	requested []string
+}
+
+func (f *fakeTrac) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q := r.URL.Query()
+	if strings.HasPrefix(r.URL.Path, "/log/") {
+		rev, _ := strconv.Atoi(q.Get("rev"))
+		stopRev, _ := strconv.Atoi(q.Get("stop_rev"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		entries, _ := parseChangelog(strings.NewReader(string(f.changelog)))
+		written := 0
+		for _, e := range entries {
+			if rev > 0 && e.Revision > rev {
+				continue
+			}
+			if written >= limit || e.Revision < stopRev {
+				break
+			}
+			written++
+			w.Write([]byte("2024-01-10  author\n\n\t[" + strconv.Itoa(e.Revision) + "]\n"))
+			for _, p := range e.Paths {
+				w.Write([]byte("\t* " + p + " (modified)\n"))
+			}
+			w.Write([]byte("\n\tmessage\n\n"))
+		}
+		return
+	}
+
+	slug := q.Get("request[slug]")
+	f.requested = append(f.requested, slug)
+	if slug == "closed-plugin" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"closed","name":"Closed Plugin","slug":"closed-plugin"}`))
+		return
+	}
+	json.NewEncoder(w).Encode(PluginInfo{
+		Name:         slug,
+		Slug:         slug,
+		Version:      "2.0",
+		DownloadLink: "https://downloads.wordpress.org/plugin/" + slug + ".2.0.zip",
+	})
+}
+
+func TestSyncChangelog(t *testing.T) {
+	changelog, err := os.ReadFile("testdata/plugins-changelog.txt")
+	require.NoError(t, err)
+	trac := &fakeTrac{changelog: changelog}
+	upstream := httptest.NewServer(trac)
+	defer upstream.Close()
+	setupCrawlerConfig(t, upstream.URL)
+	cfg.Updater.PluginsChangelogURL = upstream.URL + "/log/?format=changelog"
+	cfg.Updater.ChangelogLimit = 1
+
+	store := NewMemoryStore()
+	crawler := NewCrawler(store)
+
+	// The first run only records the head revision
+	require.NoError(t, crawler.SyncChangelog(pluginDirectory))
+	cursor, err := store.GetCrawlCursor("plugins-changelog")
+	require.NoError(t, err)
+	assert.Equal(t, 3024570, cursor.Revision)
+	assert.Empty(t, trac.requested)
+
+	// Later runs fetch the slugs committed after the last seen revision,
+	// paging back through the changelog
+	require.NoError(t, store.SetCrawlCursor("plugins-changelog", CrawlCursor{Revision: 3024567}))
+	require.NoError(t, crawler.SyncChangelog(pluginDirectory))
+	assert.Equal(t, []string{"akismet", "closed-plugin", "contact-form-7"}, trac.requested)
+
+	latest, err := store.GetLatestPluginVersion("contact-form-7")
+	require.NoError(t, err)
+	assert.Equal(t, "2.0", latest.NewVersion)
+	_, err = store.GetPluginInfo("closed-plugin")
+	assert.ErrorIs(t, err, ErrPluginInfoNotFound)
+
+	cursor, err = store.GetCrawlCursor("plugins-changelog")
+	require.NoError(t, err)
+	assert.Equal(t, 3024570, cursor.Revision)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// errChecksumMismatch marks downloads that do not match their published
+	// checksums
+	errChecksumMismatch = errors.New("checksum mismatch")
+	// errChecksumsUnavailable marks checksums that could not be fetched,
+	// which may succeed on a later attempt
+	errChecksumsUnavailable = errors.New("checksums unavailable")
+	// errNoChecksums marks core builds WordPress publishes no checksums for
+	errNoChecksums = errors.New("no checksums published")
+)
+
+// checksumClient fetches the digest files and the checksums API, which
+// answer with a few kilobytes at most
+var checksumClient = &http.Client{Timeout: time.Minute}
+
+// hexDigest matches the hex-encoded md5 and sha1 digests WordPress publishes
+var hexDigest = regexp.MustCompile(`^[0-9a-f]{32}([0-9a-f]{8})?$`)
+
+// coreChecksums are the published checksums of a core package
+type coreChecksums struct {
+	// ZipSHA1 and ZipMD5 are the digests of the zip from its .zip.sha1 and
+	// .zip.md5 files; ZipMD5 is only fetched without a .zip.sha1
+	ZipSHA1 string
+	ZipMD5  string
+	// Files maps the files of the package, relative to its wordpress/
+	// directory, to their md5 digests
+	Files map[string]string
+}
+
+// fetchCoreChecksums fetches the checksums WordPress publishes for the core
+// package of an item. It fails with errNoChecksums if none are published and
+// with errChecksumsUnavailable if they cannot be fetched.
+func fetchCoreChecksums(item DownloadItem) (*coreChecksums, error) {
+	var sums coreChecksums
+	var err error
+	if sums.ZipSHA1, err = fetchDigestFile(item.URL + ".sha1"); err != nil {
+		return nil, err
+	}
+	if sums.ZipSHA1 == "" {
+		if sums.ZipMD5, err = fetchDigestFile(item.URL + ".md5"); err != nil {
+			return nil, err
+		}
+	}
+	if sums.Files, err = fetchCoreFileChecksums(item.Version, item.Locale); err != nil {
+		return nil, err
+	}
+	if sums.ZipSHA1 == "" && sums.ZipMD5 == "" && len(sums.Files) == 0 {
+		return nil, fmt.Errorf("%w for %s", errNoChecksums, item.URL)
+	}
+	return &sums, nil
+}
+
+// fetchDigestFile returns the digest in a .sha1 or .md5 file, or "" if
+// there is no such file
+func fetchDigestFile(fileURL string) (string, error) {
+	resp, err := checksumClient.Get(fileURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: error fetching %s: %v", errChecksumsUnavailable, fileURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: error fetching %s: bad status: %s", errChecksumsUnavailable, fileURL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", fmt.Errorf("%w: error reading %s: %v", errChecksumsUnavailable, fileURL, err)
+	}
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 || !hexDigest.MatchString(strings.ToLower(fields[0])) {
+		return "", fmt.Errorf("%w: invalid digest file %s", errChecksumsUnavailable, fileURL)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// fetchCoreFileChecksums fetches the md5 digests of the files of a core
+// package from the checksums API, nil if it has none for the build
+func fetchCoreFileChecksums(version, locale string) (map[string]string, error) {
+	if locale == "" {
+		locale = "en_US"
+	}
+	query := url.Values{"version": {version}, "locale": {locale}}
+	apiURL := cfg.Worker.CoreChecksumsURL + "?" + query.Encode()
+
+	resp, err := checksumClient.Get(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error fetching checksums: %v", errChecksumsUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: error fetching checksums: bad status: %s", errChecksumsUnavailable, resp.Status)
+	}
+
+	// The API answers "checksums": false for builds it does not know
+	var response struct {
+		Checksums json.RawMessage `json:"checksums"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("%w: error decoding checksums: %v", errChecksumsUnavailable, err)
+	}
+	var files map[string]string
+	if len(response.Checksums) > 0 && string(response.Checksums) != "false" {
+		if err := json.Unmarshal(response.Checksums, &files); err != nil {
+			return nil, fmt.Errorf("%w: error decoding checksums: %v", errChecksumsUnavailable, err)
+		}
+	}
+	return files, nil
+}
+
+// verify checks a downloaded core zip, whose sha1 and md5 digests were
+// computed while it was written, against the checksums
+func (sums *coreChecksums) verify(f *os.File, size int64, sha1Sum, md5Sum string) error {
+	if sums.ZipSHA1 != "" && sums.ZipSHA1 != sha1Sum {
+		return fmt.Errorf("%w: zip sha1 is %s, expected %s", errChecksumMismatch, sha1Sum, sums.ZipSHA1)
+	}
+	if sums.ZipMD5 != "" && sums.ZipMD5 != md5Sum {
+		return fmt.Errorf("%w: zip md5 is %s, expected %s", errChecksumMismatch, md5Sum, sums.ZipMD5)
+	}
+	if len(sums.Files) == 0 {
+		return nil
+	}
+
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return fmt.Errorf("%w: unreadable zip: %v", errChecksumMismatch, err)
+	}
+	entries := make(map[string]*zip.File, len(zr.File))
+	for _, entry := range zr.File {
+		entries[strings.TrimPrefix(entry.Name, "wordpress/")] = entry
+	}
+	for name, want := range sums.Files {
+		entry, ok := entries[name]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing", errChecksumMismatch, name)
+		}
+		got, err := zipEntryMD5(entry)
+		if err != nil {
+			return fmt.Errorf("%w: error reading %s: %v", errChecksumMismatch, name, err)
+		}
+		if got != want {
+			return fmt.Errorf("%w: %s md5 is %s, expected %s", errChecksumMismatch, name, got, want)
+		}
+	}
+	return nil
+}
+
+func zipEntryMD5(entry *zip.File) (string, error) {
+	r, err := entry.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// downloadVerifiedCore stages a core package and stores it as its artifact
+// only if it matches the published checksums. Packages that do not are
+// moved to the quarantine directory.
+func (dw *DownloadWorkers) downloadVerifiedCore(item DownloadItem, resp *http.Response) error {
+	sums, err := fetchCoreChecksums(item)
+	if err != nil {
+		return err
+	}
+
+	staged, err := stageDownload(resp.Body, nil)
+	if err != nil {
+		return err
+	}
+	defer staged.discard()
+
+	artifact := newArtifact(item)
+	if err := staged.validate(resp.ContentLength, sums); err != nil {
+		if errors.Is(err, errChecksumMismatch) {
+			if path, qerr := quarantine(staged.File, artifact); qerr != nil {
+				fmt.Printf("Error quarantining %s: %v\n", artifact, qerr)
+			} else {
+				fmt.Printf("Quarantined %s as %s\n", artifact, path)
+			}
+		}
+		return err
+	}
+	return staged.publish(dw.artifacts, artifact)
+}
+
+// quarantine copies a download that failed verification to
+// <quarantine_dir>/<time>-<random>-<file name> for inspection
+func quarantine(f *os.File, a Artifact) (string, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(cfg.Worker.QuarantineDir, 0755); err != nil {
+		return "", err
+	}
+	pattern := time.Now().UTC().Format("20060102T150405Z") + "-*-" + filepath.Base(a.FileName())
+	out, err := os.CreateTemp(cfg.Worker.QuarantineDir, pattern)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, f); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), out.Close()
+}
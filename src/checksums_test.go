@@ -0,0 +1,216 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRelease serves a core package with its .zip.sha1 file and the
+// checksums API answer for it
+type fakeRelease struct {
+	zip   []byte
+	sha1  string
+	files map[string]string
+	// unavailable makes the checksums API fail
+	unavailable bool
+}
+
+func newFakeRelease(t *testing.T, files map[string]string) *fakeRelease {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	release := &fakeRelease{files: make(map[string]string)}
+	for name, content := range files {
+		w, err := zw.Create("wordpress/" + name)
+		require.NoError(t, err)
+		w.Write([]byte(content))
+		sum := md5.Sum([]byte(content))
+		release.files[name] = hex.EncodeToString(sum[:])
+	}
+	require.NoError(t, zw.Close())
+	release.zip = buf.Bytes()
+	sum := sha1.Sum(release.zip)
+	release.sha1 = hex.EncodeToString(sum[:])
+	return release
+}
+
+func (f *fakeRelease) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/release/wordpress-6.4.2.zip":
+		w.Write(f.zip)
+	case "/release/wordpress-6.4.2.zip.sha1":
+		if f.sha1 == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(f.sha1 + "  wordpress-6.4.2.zip\n"))
+	case "/core/checksums/1.0/":
+		if f.unavailable {
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Query().Get("version") != "6.4.2" || r.URL.Query().Get("locale") != "en_US" {
+			w.Write([]byte(`{"checksums":false}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"checksums": f.files})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func setupChecksumTest(t *testing.T, release *fakeRelease) (*MemoryStore, *DownloadWorkers, DownloadItem) {
+	upstream := httptest.NewServer(release)
+	t.Cleanup(upstream.Close)
+	setupProxyConfig(t, upstream.URL)
+	cfg.Worker.CoreChecksumsURL = upstream.URL + "/core/checksums/1.0/"
+	cfg.Worker.QuarantineDir = t.TempDir()
+
+	store := NewMemoryStore()
+	item := DownloadItem{Type: "core", Version: "6.4.2", URL: upstream.URL + "/release/wordpress-6.4.2.zip"}
+	return store, NewDownloadWorkers(store, NewFileArtifactStore(cfg.Paths)), item
+}
+
+func TestWorkerVerifiesCorePackage(t *testing.T) {
+	release := newFakeRelease(t, map[string]string{"wp-load.php": "<?php // load", "wp-includes/version.php": "<?php $wp_version = '6.4.2';"})
+	store, workers, item := setupChecksumTest(t, release)
+
+	workers.process(0, item)
+	stored, err := os.ReadFile(filepath.Join(cfg.Paths.CoreDir, "wordpress-6.4.2.zip"))
+	require.NoError(t, err)
+	assert.Equal(t, release.zip, stored)
+	assert.Empty(t, store.queue)
+}
+
+func TestWorkerQuarantinesTamperedCorePackage(t *testing.T) {
+	release := newFakeRelease(t, map[string]string{"wp-load.php": "<?php // load"})
+	store, workers, item := setupChecksumTest(t, release)
+
+	// A zip matching its .sha1 but not the file checksums
+	published := release.files
+	*release = *newFakeRelease(t, map[string]string{"wp-load.php": "<?php // tampered"})
+	release.files = published
+
+	workers.process(0, item)
+	_, err := os.Stat(filepath.Join(cfg.Paths.CoreDir, "wordpress-6.4.2.zip"))
+	assert.True(t, os.IsNotExist(err))
+	quarantined, err := filepath.Glob(filepath.Join(cfg.Worker.QuarantineDir, "*-wordpress-6.4.2.zip"))
+	require.NoError(t, err)
+	require.Len(t, quarantined, 1)
+	content, err := os.ReadFile(quarantined[0])
+	require.NoError(t, err)
+	assert.Equal(t, release.zip, content)
+
+	// The job is retried until it has been tried worker.max_attempts times
+	require.Len(t, store.queue, 1)
+	assert.Equal(t, 1, store.queue[0].Attempts)
+	for len(store.queue) > 0 {
+		retry, err := store.PopDownload()
+		require.NoError(t, err)
+		workers.process(0, retry)
+	}
+	quarantined, err = filepath.Glob(filepath.Join(cfg.Worker.QuarantineDir, "*-wordpress-6.4.2.zip"))
+	require.NoError(t, err)
+	assert.Len(t, quarantined, cfg.Worker.MaxAttempts)
+}
+
+func TestWorkerRejectsTruncatedCorePackage(t *testing.T) {
+	release := newFakeRelease(t, map[string]string{"wp-load.php": "<?php // load"})
+	_, workers, item := setupChecksumTest(t, release)
+	release.zip = release.zip[:len(release.zip)/2]
+
+	err := workers.downloadFile(item)
+	assert.ErrorIs(t, err, errChecksumMismatch)
+	assert.ErrorContains(t, err, "zip sha1")
+	_, err = os.Stat(filepath.Join(cfg.Paths.CoreDir, "wordpress-6.4.2.zip"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWorkerRequiresPublishedChecksums(t *testing.T) {
+	release := newFakeRelease(t, map[string]string{"wp-load.php": "<?php // load"})
+	_, workers, item := setupChecksumTest(t, release)
+	item.Locale = "de_DE"
+	release.sha1 = ""
+
+	// No .sha1 for the build and no checksums for the locale
+	err := workers.downloadFile(item)
+	assert.ErrorIs(t, err, errNoChecksums)
+	assert.NotErrorIs(t, err, errChecksumMismatch)
+	quarantined, err := filepath.Glob(filepath.Join(cfg.Worker.QuarantineDir, "*"))
+	require.NoError(t, err)
+	assert.Empty(t, quarantined)
+}
+
+func TestWorkerRetriesUnavailableChecksums(t *testing.T) {
+	release := newFakeRelease(t, map[string]string{"wp-load.php": "<?php // load"})
+	store, workers, item := setupChecksumTest(t, release)
+	release.unavailable = true
+
+	workers.process(0, item)
+	require.Len(t, store.queue, 1)
+	assert.Equal(t, 1, store.queue[0].Attempts)
+	_, err := os.Stat(filepath.Join(cfg.Paths.CoreDir, "wordpress-6.4.2.zip"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Stored once the API answers again
+	release.unavailable = false
+	retry, err := store.PopDownload()
+	require.NoError(t, err)
+	workers.process(0, retry)
+	assert.Empty(t, store.queue)
+	assert.FileExists(t, filepath.Join(cfg.Paths.CoreDir, "wordpress-6.4.2.zip"))
+}
+
+func TestChecksumFetchTimesOut(t *testing.T) {
+	hang := make(chan struct{})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer api.Close()
+	defer close(hang)
+	setupProxyConfig(t, "http://127.0.0.1:1")
+	cfg.Worker.CoreChecksumsURL = api.URL
+
+	client := checksumClient
+	checksumClient = &http.Client{Timeout: 50 * time.Millisecond}
+	t.Cleanup(func() { checksumClient = client })
+
+	_, err := fetchDigestFile(api.URL + "/wordpress-6.4.2.zip.sha1")
+	assert.ErrorIs(t, err, errChecksumsUnavailable)
+	_, err = fetchCoreFileChecksums("6.4.2", "")
+	assert.ErrorIs(t, err, errChecksumsUnavailable)
+}
+
+func TestProxyVerifiesCorePackage(t *testing.T) {
+	release := newFakeRelease(t, map[string]string{"wp-load.php": "<?php // load"})
+	setupChecksumTest(t, release)
+	router := NewServer(NewMemoryStore(), NewFileArtifactStore(cfg.Paths)).setupRouter()
+	published := release.files
+	release.files = map[string]string{"wp-load.php": published["wp-load.php"][1:] + "0"}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/core/6.4.2.zip", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 502, w.Code)
+
+	release.files = published
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, release.zip, w.Body.Bytes())
+}
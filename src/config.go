@@ -0,0 +1,562 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prepended to the upper-cased flag name to form the
+// environment variable overriding a setting, e.g. WPMIRROR_REDIS_ADDR.
+const envPrefix = "WPMIRROR_"
+
+// Config holds every runtime setting of wp-mirror
+type Config struct {
+	Storage   StorageConfig   `yaml:"storage"`
+	Redis     RedisConfig     `yaml:"redis"`
+	Bolt      BoltConfig      `yaml:"bolt"`
+	Server    ServerConfig    `yaml:"server"`
+	Paths     PathsConfig     `yaml:"paths"`
+	Artifacts ArtifactsConfig `yaml:"artifacts"`
+	S3        S3Config        `yaml:"s3"`
+	Worker    WorkerConfig    `yaml:"worker"`
+	Checker   CheckerConfig   `yaml:"checker"`
+	Updater   UpdaterConfig   `yaml:"updater"`
+	Crawler   CrawlerConfig   `yaml:"crawler"`
+	Scope     ScopeConfig     `yaml:"scope"`
+	Discovery DiscoveryConfig `yaml:"discovery"`
+	Retention RetentionConfig `yaml:"retention"`
+	Sites     SitesConfig     `yaml:"sites"`
+	Proxy     ProxyConfig     `yaml:"proxy"`
+}
+
+type StorageConfig struct {
+	// Backend selects the MetadataStore: "redis", "bolt" or "memory"
+	Backend string `yaml:"backend"`
+}
+
+type RedisConfig struct {
+	Addr string `yaml:"addr"`
+}
+
+type BoltConfig struct {
+	Path string `yaml:"path"`
+}
+
+type ServerConfig struct {
+	Listen string `yaml:"listen"`
+	// AdminToken is the bearer token of the admin API, which is disabled
+	// when it is empty
+	AdminToken string `yaml:"admin_token"`
+}
+
+type PathsConfig struct {
+	// PublicFolder is deprecated. If set, the directories below left at
+	// their defaults move under it; see applyPublicFolder.
+	PublicFolder string `yaml:"public_folder,omitempty"`
+	CoreDir      string `yaml:"core_dir"`
+	PluginsDir   string `yaml:"plugins_dir"`
+	ThemesDir    string `yaml:"themes_dir"`
+	// BlobsDir holds the content addressed blobs when
+	// artifacts.content_addressed is set
+	BlobsDir string `yaml:"blobs_dir"`
+	// StagingDir holds downloads until they are validated and stored, the
+	// system temporary directory if empty
+	StagingDir string `yaml:"staging_dir"`
+}
+
+type ArtifactsConfig struct {
+	// Backend selects the ArtifactStore: "file" or "s3"
+	Backend string `yaml:"backend"`
+	// ContentAddressed stores every distinct zip once, as a blob named by
+	// its SHA-256 digest, with the artifacts mapped to digests in the
+	// metadata store
+	ContentAddressed bool `yaml:"content_addressed"`
+}
+
+type S3Config struct {
+	// Endpoint is the base URL of the S3-compatible service, e.g.
+	// https://s3.eu-central-1.amazonaws.com or http://localhost:9000
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	// Redirect answers downloads with a redirect to a presigned URL instead
+	// of streaming them through the server
+	Redirect bool `yaml:"redirect"`
+	// PresignExpiry is how long a presigned URL stays valid
+	PresignExpiry time.Duration `yaml:"presign_expiry"`
+}
+
+type WorkerConfig struct {
+	MaxWorkers int `yaml:"max_workers"`
+	// MaxAttempts is how often a download failing verification or
+	// validation is tried
+	MaxAttempts int `yaml:"max_attempts"`
+	// VerifyCore checks core packages against the checksums WordPress
+	// publishes before storing them
+	VerifyCore bool `yaml:"verify_core"`
+	// CoreChecksumsURL is the core checksums API listing the md5 digests
+	// of the files of a release
+	CoreChecksumsURL string `yaml:"core_checksums_url"`
+	// QuarantineDir keeps the downloads that failed verification
+	QuarantineDir string `yaml:"quarantine_dir"`
+}
+
+type CheckerConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+type UpdaterConfig struct {
+	Interval      time.Duration `yaml:"interval"`
+	LockDuration  time.Duration `yaml:"lock_duration"`
+	CoreAPIURL    string        `yaml:"core_api_url"`
+	PluginsAPIURL string        `yaml:"plugins_api_url"`
+	ThemesAPIURL  string        `yaml:"themes_api_url"`
+	// SyncMode selects how plugin and theme changes are found: "crawl" or
+	// "changelog"
+	SyncMode            string `yaml:"sync_mode"`
+	PluginsChangelogURL string `yaml:"plugins_changelog_url"`
+	ThemesChangelogURL  string `yaml:"themes_changelog_url"`
+	// ChangelogLimit is the number of revisions read per changelog request
+	ChangelogLimit int `yaml:"changelog_limit"`
+}
+
+type CrawlerConfig struct {
+	// Mode is the crawl run by the crawl command: "full" or "delta"
+	Mode string `yaml:"mode"`
+	// PerPage is the number of entries requested per directory page
+	PerPage int `yaml:"per_page"`
+	// RequestInterval is the pause between two directory page requests
+	RequestInterval time.Duration `yaml:"request_interval"`
+}
+
+type DiscoveryConfig struct {
+	// Interval is the time between two fetches of the demanded slugs
+	Interval time.Duration `yaml:"interval"`
+	// RetryAfter is how long a slug upstream does not serve is left alone
+	RetryAfter time.Duration `yaml:"retry_after"`
+}
+
+type RetentionConfig struct {
+	// Versions is the number of newest releases kept per plugin and theme
+	Versions int `yaml:"versions"`
+}
+
+type SitesConfig struct {
+	// Groups maps a group name to the site host patterns it contains
+	Groups map[string][]string `yaml:"groups,omitempty"`
+}
+
+type ProxyConfig struct {
+	// Enabled makes the server fetch zips missing on disk from upstream
+	Enabled bool `yaml:"enabled"`
+	// DownloadsURL is the base URL of the upstream release, plugin and
+	// theme zips
+	DownloadsURL string `yaml:"downloads_url"`
+}
+
+// cfg is the resolved configuration used by all components
+var cfg = DefaultConfig()
+
+// DefaultConfig returns the built-in settings used when nothing overrides them
+func DefaultConfig() *Config {
+	return &Config{
+		Storage: StorageConfig{
+			Backend: "redis",
+		},
+		Redis: RedisConfig{
+			Addr: "localhost:6379",
+		},
+		Bolt: BoltConfig{
+			Path: "./wp-mirror.db",
+		},
+		Server: ServerConfig{
+			Listen: ":8080",
+		},
+		Paths: PathsConfig{
+			CoreDir:    "./public/core",
+			PluginsDir: "./public/plugins",
+			ThemesDir:  "./public/themes",
+			BlobsDir:   "./public/blobs",
+		},
+		Artifacts: ArtifactsConfig{
+			Backend: "file",
+		},
+		S3: S3Config{
+			Region:        "us-east-1",
+			PresignExpiry: 15 * time.Minute,
+		},
+		Worker: WorkerConfig{
+			MaxWorkers:       5,
+			MaxAttempts:      3,
+			VerifyCore:       true,
+			CoreChecksumsURL: "https://api.wordpress.org/core/checksums/1.0/",
+			QuarantineDir:    "./quarantine",
+		},
+		Checker: CheckerConfig{
+			Interval: 1 * time.Hour,
+		},
+		Updater: UpdaterConfig{
+			Interval:            1 * time.Hour,
+			LockDuration:        65 * time.Minute,
+			CoreAPIURL:          "https://api.wordpress.org/core/version-check/1.7/",
+			PluginsAPIURL:       "https://api.wordpress.org/plugins/info/1.2/?action=query_plugins&request[fields][sections]=1&request[fields][versions]=1&request[fields][contributors]=1",
+			ThemesAPIURL:        "https://api.wordpress.org/themes/info/1.1/?action=query_themes&request[fields][sections]=1&request[fields][versions]=1&request[fields][tags]=1&request[fields][active_installs]=1&request[fields][last_updated]=1&request[fields][creation_time]=1",
+			SyncMode:            SyncCrawl,
+			PluginsChangelogURL: "https://plugins.trac.wordpress.org/log/?verbose=on&format=changelog",
+			ThemesChangelogURL:  "https://themes.trac.wordpress.org/log/?verbose=on&format=changelog",
+			ChangelogLimit:      500,
+		},
+		Crawler: CrawlerConfig{
+			Mode:            CrawlDelta,
+			PerPage:         100,
+			RequestInterval: 1 * time.Second,
+		},
+		Discovery: DiscoveryConfig{
+			Interval:   10 * time.Minute,
+			RetryAfter: 24 * time.Hour,
+		},
+		Retention: RetentionConfig{
+			Versions: 5,
+		},
+		Proxy: ProxyConfig{
+			DownloadsURL: "https://downloads.wordpress.org",
+		},
+	}
+}
+
+// bindFlags registers a flag for every setting, writing straight into c
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Storage.Backend, "storage-backend", c.Storage.Backend, "metadata store backend (redis, bolt or memory)")
+	fs.StringVar(&c.Redis.Addr, "redis-addr", c.Redis.Addr, "Redis server address")
+	fs.StringVar(&c.Bolt.Path, "bolt-path", c.Bolt.Path, "path of the bolt database file")
+	fs.StringVar(&c.Server.Listen, "listen", c.Server.Listen, "HTTP listen address")
+	fs.StringVar(&c.Server.AdminToken, "admin-token", c.Server.AdminToken, "bearer token of the admin API (disabled if empty)")
+	fs.StringVar(&c.Paths.PublicFolder, "public-folder", c.Paths.PublicFolder, "deprecated: parent of the core, plugins, themes and blobs directories left at their defaults")
+	fs.StringVar(&c.Paths.CoreDir, "core-dir", c.Paths.CoreDir, "directory core zips are stored in")
+	fs.StringVar(&c.Paths.PluginsDir, "plugins-dir", c.Paths.PluginsDir, "directory plugin zips are stored in")
+	fs.StringVar(&c.Paths.ThemesDir, "themes-dir", c.Paths.ThemesDir, "directory theme zips are stored in")
+	fs.StringVar(&c.Paths.BlobsDir, "blobs-dir", c.Paths.BlobsDir, "directory content addressed blobs are stored in")
+	fs.StringVar(&c.Paths.StagingDir, "staging-dir", c.Paths.StagingDir, "directory downloads are staged in before they are stored (default: system temporary directory)")
+	fs.StringVar(&c.Artifacts.Backend, "artifact-backend", c.Artifacts.Backend, "artifact store backend (file or s3)")
+	fs.BoolVar(&c.Artifacts.ContentAddressed, "content-addressed", c.Artifacts.ContentAddressed, "store each distinct zip once by its SHA-256 digest")
+	fs.StringVar(&c.S3.Endpoint, "s3-endpoint", c.S3.Endpoint, "base URL of the S3-compatible service")
+	fs.StringVar(&c.S3.Region, "s3-region", c.S3.Region, "region requests to S3 are signed for")
+	fs.StringVar(&c.S3.Bucket, "s3-bucket", c.S3.Bucket, "bucket the zips are stored in")
+	fs.StringVar(&c.S3.Prefix, "s3-prefix", c.S3.Prefix, "key prefix of the zips in the bucket")
+	fs.StringVar(&c.S3.AccessKey, "s3-access-key", c.S3.AccessKey, "S3 access key ID")
+	fs.StringVar(&c.S3.SecretKey, "s3-secret-key", c.S3.SecretKey, "S3 secret access key")
+	fs.BoolVar(&c.S3.Redirect, "s3-redirect", c.S3.Redirect, "redirect downloads to presigned S3 URLs instead of streaming them")
+	fs.DurationVar(&c.S3.PresignExpiry, "s3-presign-expiry", c.S3.PresignExpiry, "validity of presigned S3 URLs")
+	fs.IntVar(&c.Worker.MaxWorkers, "max-workers", c.Worker.MaxWorkers, "number of concurrent download workers")
+	fs.IntVar(&c.Worker.MaxAttempts, "max-attempts", c.Worker.MaxAttempts, "times a download failing verification or validation is tried")
+	fs.BoolVar(&c.Worker.VerifyCore, "verify-core", c.Worker.VerifyCore, "verify core packages against the published checksums")
+	fs.StringVar(&c.Worker.CoreChecksumsURL, "core-checksums-url", c.Worker.CoreChecksumsURL, "upstream core checksums API URL")
+	fs.StringVar(&c.Worker.QuarantineDir, "quarantine-dir", c.Worker.QuarantineDir, "directory downloads failing verification are moved to")
+	fs.DurationVar(&c.Checker.Interval, "check-interval", c.Checker.Interval, "time between download checks")
+	fs.DurationVar(&c.Updater.Interval, "update-interval", c.Updater.Interval, "time between WordPress.org update runs")
+	fs.DurationVar(&c.Updater.LockDuration, "lock-duration", c.Updater.LockDuration, "expiry of the updater lock")
+	fs.StringVar(&c.Updater.CoreAPIURL, "core-api-url", c.Updater.CoreAPIURL, "upstream core version-check URL")
+	fs.StringVar(&c.Updater.PluginsAPIURL, "plugins-api-url", c.Updater.PluginsAPIURL, "upstream plugins query URL")
+	fs.StringVar(&c.Updater.ThemesAPIURL, "themes-api-url", c.Updater.ThemesAPIURL, "upstream themes query URL")
+	fs.StringVar(&c.Updater.SyncMode, "sync-mode", c.Updater.SyncMode, "how the updater finds plugin and theme changes (crawl or changelog)")
+	fs.StringVar(&c.Updater.PluginsChangelogURL, "plugins-changelog-url", c.Updater.PluginsChangelogURL, "Trac changelog URL of the plugins SVN repository")
+	fs.StringVar(&c.Updater.ThemesChangelogURL, "themes-changelog-url", c.Updater.ThemesChangelogURL, "Trac changelog URL of the themes SVN repository")
+	fs.IntVar(&c.Updater.ChangelogLimit, "changelog-limit", c.Updater.ChangelogLimit, "revisions read per changelog request")
+	fs.StringVar(&c.Crawler.Mode, "crawl-mode", c.Crawler.Mode, "crawl run by the crawl command (full or delta)")
+	fs.IntVar(&c.Crawler.PerPage, "crawl-per-page", c.Crawler.PerPage, "directory entries requested per page")
+	fs.DurationVar(&c.Crawler.RequestInterval, "crawl-request-interval", c.Crawler.RequestInterval, "pause between directory page requests")
+	fs.DurationVar(&c.Discovery.Interval, "discovery-interval", c.Discovery.Interval, "time between fetches of demanded plugins and themes")
+	fs.DurationVar(&c.Discovery.RetryAfter, "discovery-retry-after", c.Discovery.RetryAfter, "time before a slug unavailable upstream is looked up again")
+	fs.BoolVar(&c.Proxy.Enabled, "proxy-downloads", c.Proxy.Enabled, "fetch zips missing on disk from upstream while serving them")
+	fs.StringVar(&c.Proxy.DownloadsURL, "downloads-url", c.Proxy.DownloadsURL, "base URL of the upstream zips")
+	fs.IntVar(&c.Retention.Versions, "keep-versions", c.Retention.Versions, "number of newest releases kept per plugin and theme")
+	fs.Var((*listValue)(&c.Scope.Plugins.Include), "plugins-include", "comma-separated plugin slug patterns to mirror (default all)")
+	fs.Var((*listValue)(&c.Scope.Plugins.Exclude), "plugins-exclude", "comma-separated plugin slug patterns never to mirror")
+	fs.Var((*listValue)(&c.Scope.Themes.Include), "themes-include", "comma-separated theme slug patterns to mirror (default all)")
+	fs.Var((*listValue)(&c.Scope.Themes.Exclude), "themes-exclude", "comma-separated theme slug patterns never to mirror")
+}
+
+// loadConfig resolves the configuration from, in increasing priority, the
+// built-in defaults, the YAML config file, WPMIRROR_* environment variables
+// and command line flags.
+func loadConfig(name string, args []string) (*Config, error) {
+	c := DefaultConfig()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "path to a YAML config file")
+	c.bindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	// Remember the explicitly set flags, then rebuild from the bottom layer
+	overrides := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		overrides[f.Name] = f.Value.String()
+	})
+	*c = *DefaultConfig()
+
+	if *configFile != "" {
+		if err := c.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		key := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(key); ok && err == nil {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %w", value, key, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range overrides {
+		if err := fs.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	c.applyPublicFolder()
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return c, nil
+}
+
+// applyPublicFolder maps the deprecated paths.public_folder onto the zip
+// directories still at their defaults, <public_folder>/core, plugins, themes
+// and blobs, and warns that it is deprecated
+func (c *Config) applyPublicFolder() {
+	if c.Paths.PublicFolder == "" {
+		return
+	}
+	log.Printf("paths.public_folder is deprecated; set paths.core_dir, paths.plugins_dir, paths.themes_dir and paths.blobs_dir instead")
+	defaults := DefaultConfig().Paths
+	for _, dir := range []struct {
+		value    *string
+		fallback string
+		name     string
+	}{
+		{&c.Paths.CoreDir, defaults.CoreDir, "core"},
+		{&c.Paths.PluginsDir, defaults.PluginsDir, "plugins"},
+		{&c.Paths.ThemesDir, defaults.ThemesDir, "themes"},
+		{&c.Paths.BlobsDir, defaults.BlobsDir, "blobs"},
+	} {
+		if *dir.value == dir.fallback {
+			*dir.value = filepath.Join(c.Paths.PublicFolder, dir.name)
+			log.Printf("Using %s for paths.%s_dir", *dir.value, dir.name)
+		}
+	}
+}
+
+// loadFile overlays the settings found in a YAML file onto c
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks that the resolved settings are usable
+func (c *Config) Validate() error {
+	var errs []error
+
+	switch c.Storage.Backend {
+	case "redis", "bolt", "memory":
+	default:
+		errs = append(errs, fmt.Errorf("storage.backend must be redis, bolt or memory, got %q", c.Storage.Backend))
+	}
+	if c.Storage.Backend == "redis" && c.Redis.Addr == "" {
+		errs = append(errs, errors.New("redis.addr must not be empty"))
+	}
+	if c.Storage.Backend == "bolt" && c.Bolt.Path == "" {
+		errs = append(errs, errors.New("bolt.path must not be empty"))
+	}
+	if c.Server.Listen == "" {
+		errs = append(errs, errors.New("server.listen must not be empty"))
+	}
+	switch c.Artifacts.Backend {
+	case "file":
+		for _, dir := range []struct{ name, value string }{
+			{"paths.core_dir", c.Paths.CoreDir},
+			{"paths.plugins_dir", c.Paths.PluginsDir},
+			{"paths.themes_dir", c.Paths.ThemesDir},
+		} {
+			if dir.value == "" {
+				errs = append(errs, fmt.Errorf("%s must not be empty", dir.name))
+			}
+		}
+		if c.Artifacts.ContentAddressed && c.Paths.BlobsDir == "" {
+			errs = append(errs, errors.New("paths.blobs_dir must not be empty"))
+		}
+		if c.S3.Redirect {
+			errs = append(errs, errors.New("s3.redirect requires artifacts.backend s3"))
+		}
+	case "s3":
+		if !isAbsoluteURL(c.S3.Endpoint) {
+			errs = append(errs, errors.New("s3.endpoint must be an absolute URL"))
+		}
+		if c.S3.Bucket == "" || strings.Contains(c.S3.Bucket, "/") {
+			errs = append(errs, errors.New("s3.bucket must be a bucket name"))
+		}
+		if c.S3.Region == "" {
+			errs = append(errs, errors.New("s3.region must not be empty"))
+		}
+		if c.S3.AccessKey == "" || c.S3.SecretKey == "" {
+			errs = append(errs, errors.New("s3.access_key and s3.secret_key must not be empty"))
+		}
+		if c.S3.Redirect && (c.S3.PresignExpiry < time.Second || c.S3.PresignExpiry > 7*24*time.Hour) {
+			errs = append(errs, errors.New("s3.presign_expiry must be between 1s and 168h"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("artifacts.backend must be file or s3, got %q", c.Artifacts.Backend))
+	}
+	if c.Worker.MaxWorkers < 1 {
+		errs = append(errs, errors.New("worker.max_workers must be at least 1"))
+	}
+	if c.Worker.MaxAttempts < 1 {
+		errs = append(errs, errors.New("worker.max_attempts must be at least 1"))
+	}
+	if c.Worker.VerifyCore {
+		if !isAbsoluteURL(c.Worker.CoreChecksumsURL) {
+			errs = append(errs, errors.New("worker.core_checksums_url must be an absolute URL"))
+		}
+		if c.Worker.QuarantineDir == "" {
+			errs = append(errs, errors.New("worker.quarantine_dir must not be empty"))
+		}
+	}
+	if c.Checker.Interval <= 0 {
+		errs = append(errs, errors.New("checker.interval must be positive"))
+	}
+	if c.Updater.Interval <= 0 {
+		errs = append(errs, errors.New("updater.interval must be positive"))
+	}
+	if c.Updater.LockDuration <= 0 {
+		errs = append(errs, errors.New("updater.lock_duration must be positive"))
+	}
+	for _, u := range []struct{ name, value string }{
+		{"updater.core_api_url", c.Updater.CoreAPIURL},
+		{"updater.plugins_api_url", c.Updater.PluginsAPIURL},
+		{"updater.themes_api_url", c.Updater.ThemesAPIURL},
+		{"updater.plugins_changelog_url", c.Updater.PluginsChangelogURL},
+		{"updater.themes_changelog_url", c.Updater.ThemesChangelogURL},
+	} {
+		if !isAbsoluteURL(u.value) {
+			errs = append(errs, fmt.Errorf("%s must be an absolute URL", u.name))
+		}
+	}
+
+	switch c.Updater.SyncMode {
+	case SyncCrawl, SyncChangelog:
+	default:
+		errs = append(errs, fmt.Errorf("updater.sync_mode must be crawl or changelog, got %q", c.Updater.SyncMode))
+	}
+	if c.Updater.ChangelogLimit < 1 {
+		errs = append(errs, errors.New("updater.changelog_limit must be at least 1"))
+	}
+	switch c.Crawler.Mode {
+	case CrawlFull, CrawlDelta:
+	default:
+		errs = append(errs, fmt.Errorf("crawler.mode must be full or delta, got %q", c.Crawler.Mode))
+	}
+	if c.Crawler.PerPage < 1 || c.Crawler.PerPage > maxQueryPerPage {
+		errs = append(errs, fmt.Errorf("crawler.per_page must be between 1 and %d", maxQueryPerPage))
+	}
+	if c.Crawler.RequestInterval < 0 {
+		errs = append(errs, errors.New("crawler.request_interval must not be negative"))
+	}
+
+	if c.Discovery.Interval <= 0 {
+		errs = append(errs, errors.New("discovery.interval must be positive"))
+	}
+	if c.Proxy.Enabled && !isAbsoluteURL(c.Proxy.DownloadsURL) {
+		errs = append(errs, errors.New("proxy.downloads_url must be an absolute URL"))
+	}
+	if c.Retention.Versions < 1 {
+		errs = append(errs, errors.New("retention.versions must be at least 1"))
+	}
+	for group, patterns := range c.Sites.Groups {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("sites.groups.%s: invalid pattern %q", group, pattern))
+			}
+		}
+	}
+	errs = append(errs, c.Scope.Plugins.validate("scope.plugins")...)
+	errs = append(errs, c.Scope.Themes.validate("scope.themes")...)
+
+	return errors.Join(errs...)
+}
+
+// listValue is a comma-separated list flag. Setting it replaces the list, so
+// a flag or environment variable overrides the config file.
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+// Print writes the resolved configuration as YAML, with the admin token and
+// the S3 secret key redacted
+func (c *Config) Print() error {
+	printed := *c
+	if printed.Server.AdminToken != "" {
+		printed.Server.AdminToken = "<redacted>"
+	}
+	if printed.S3.SecretKey != "" {
+		printed.S3.SecretKey = "<redacted>"
+	}
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(&printed)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfigFile writes a YAML config file and returns its path
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "wp-mirror.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+// setEnv sets the environment variables for the duration of the test
+func setEnv(t *testing.T, env map[string]string) {
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+const testConfigFile = `
+redis:
+  addr: file:6379
+worker:
+  max_workers: 8
+checker:
+  interval: 30m
+`
+
+func TestConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		env      map[string]string
+		args     []string
+		addr     string
+		workers  int
+		interval time.Duration
+	}{
+		{"defaults", "", nil, nil, "localhost:6379", 5, time.Hour},
+		{"file", testConfigFile, nil, nil, "file:6379", 8, 30 * time.Minute},
+		{"env over file", testConfigFile, map[string]string{"WPMIRROR_REDIS_ADDR": "env:6379"}, nil, "env:6379", 8, 30 * time.Minute},
+		{"env over defaults", "", map[string]string{"WPMIRROR_MAX_WORKERS": "2", "WPMIRROR_CHECK_INTERVAL": "5m"}, nil, "localhost:6379", 2, 5 * time.Minute},
+		{"flags over env", testConfigFile, map[string]string{"WPMIRROR_REDIS_ADDR": "env:6379", "WPMIRROR_MAX_WORKERS": "2"}, []string{"-redis-addr", "flag:6379"}, "flag:6379", 2, 30 * time.Minute},
+		{"flag set to the default", testConfigFile, nil, []string{"-max-workers=5"}, "file:6379", 5, 30 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, tt.file)}, args...)
+			}
+
+			c, err := loadConfig("serve", args)
+			require.NoError(t, err)
+			assert.Equal(t, tt.addr, c.Redis.Addr)
+			assert.Equal(t, tt.workers, c.Worker.MaxWorkers)
+			assert.Equal(t, tt.interval, c.Checker.Interval)
+		})
+	}
+}
+
+func TestConfigFileFromEnv(t *testing.T) {
+	t.Setenv("WPMIRROR_CONFIG", writeConfigFile(t, testConfigFile))
+
+	c, err := loadConfig("serve", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "file:6379", c.Redis.Addr)
+}
+
+func TestConfigLists(t *testing.T) {
+	file := `
+scope:
+  plugins:
+    include: [akismet, "woo*"]
+`
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		include []string
+	}{
+		{"default", "", nil, nil, nil},
+		{"file", file, nil, nil, []string{"akismet", "woo*"}},
+		{"env replaces file", file, map[string]string{"WPMIRROR_PLUGINS_INCLUDE": "jetpack, yoast-*,,"}, nil, []string{"jetpack", "yoast-*"}},
+		{"flag replaces env", file, map[string]string{"WPMIRROR_PLUGINS_INCLUDE": "jetpack"}, []string{"-plugins-include", "hello-dolly"}, []string{"hello-dolly"}},
+		{"empty flag clears", file, nil, []string{"-plugins-include="}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, tt.file)}, args...)
+			}
+
+			c, err := loadConfig("serve", args)
+			require.NoError(t, err)
+			assert.Equal(t, tt.include, c.Scope.Plugins.Include)
+		})
+	}
+}
+
+func TestConfigInvalidValues(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		err  string
+	}{
+		{"unknown file setting", "redis:\n  address: localhost:6379\n", nil, nil, "field address not found"},
+		{"malformed file", "redis: [\n", nil, nil, "error parsing config file"},
+		{"missing file", "", nil, []string{"-config", "/nonexistent/wp-mirror.yaml"}, "error reading config file"},
+		{"env", "", map[string]string{"WPMIRROR_MAX_WORKERS": "many"}, nil, `invalid value "many" for WPMIRROR_MAX_WORKERS`},
+		{"flag", "", nil, []string{"-check-interval", "soon"}, `invalid value "soon" for flag -check-interval`},
+		{"unknown flag", "", nil, []string{"-redis-address", "localhost:6379"}, "flag provided but not defined"},
+		{"arguments", "", nil, []string{"extra"}, "unexpected arguments: extra"},
+		{"validation", "", map[string]string{"WPMIRROR_STORAGE_BACKEND": "sql"}, nil, `invalid configuration: storage.backend must be redis, bolt or memory, got "sql"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, tt.file)}, args...)
+			}
+
+			_, err := loadConfig("serve", args)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestValidateJoinsErrors(t *testing.T) {
+	require.NoError(t, DefaultConfig().Validate())
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		errs   []string
+	}{
+		{"one", func(c *Config) { c.Retention.Versions = 0 }, []string{
+			"retention.versions must be at least 1",
+		}},
+		{"several", func(c *Config) {
+			c.Storage.Backend = "sql"
+			c.Worker.MaxWorkers = 0
+			c.Crawler.PerPage = maxQueryPerPage + 1
+			c.Scope.Themes.Exclude = []string{"["}
+		}, []string{
+			`storage.backend must be redis, bolt or memory, got "sql"`,
+			"worker.max_workers must be at least 1",
+			"crawler.per_page must be between 1 and 250",
+			`scope.themes: invalid pattern "["`,
+		}},
+		{"s3", func(c *Config) { c.Artifacts.Backend = "s3" }, []string{
+			"s3.endpoint must be an absolute URL",
+			"s3.bucket must be a bucket name",
+			"s3.access_key and s3.secret_key must not be empty",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultConfig()
+			tt.modify(c)
+
+			err := c.Validate()
+			require.Error(t, err)
+			var joined interface{ Unwrap() []error }
+			require.True(t, errors.As(err, &joined))
+			var messages []string
+			for _, e := range joined.Unwrap() {
+				messages = append(messages, e.Error())
+			}
+			assert.Equal(t, tt.errs, messages)
+		})
+	}
+}
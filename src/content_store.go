@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ContentStore is an ArtifactStore keeping every distinct content once, as
+// a blob named by its SHA-256 digest in an underlying ArtifactStore. The
+// artifacts are mapped to their digests in the metadata store, and a blob is
+// deleted with the last artifact mapped to it.
+type ContentStore struct {
+	blobs ArtifactStore
+	store MetadataStore
+}
+
+func NewContentStore(blobs ArtifactStore, store MetadataStore) *ContentStore {
+	return &ContentStore{blobs: blobs, store: store}
+}
+
+// key returns the metadata key of an artifact, <type>/<file name>
+func (s *ContentStore) key(a Artifact) (string, error) {
+	if a.Type == "blob" {
+		return "", fmt.Errorf("invalid artifact type %q", a.Type)
+	}
+	if err := a.validate(); err != nil {
+		return "", err
+	}
+	return a.String(), nil
+}
+
+// blob returns the blob an artifact is mapped to
+func (s *ContentStore) blob(a Artifact) (Artifact, error) {
+	key, err := s.key(a)
+	if err != nil {
+		return Artifact{}, err
+	}
+	digest, err := s.store.GetArtifactDigest(key)
+	if err != nil {
+		return Artifact{}, err
+	}
+	return blobArtifact(digest), nil
+}
+
+// Stat stats the artifact's blob. An artifact whose blob is gone counts as
+// missing, so the checker fetches it again.
+func (s *ContentStore) Stat(a Artifact) (ArtifactInfo, error) {
+	blob, err := s.blob(a)
+	if err != nil {
+		return ArtifactInfo{}, err
+	}
+	info, err := s.blobs.Stat(blob)
+	info.Digest = blob.Version
+	return info, err
+}
+
+// Open opens the artifact's blob
+func (s *ContentStore) Open(a Artifact) (io.ReadCloser, ArtifactInfo, error) {
+	blob, err := s.blob(a)
+	if err != nil {
+		return nil, ArtifactInfo{}, err
+	}
+	r, info, err := s.blobs.Open(blob)
+	info.Digest = blob.Version
+	return r, info, err
+}
+
+// Create hashes the artifact into the staging directory. Commit stores it as a
+// blob unless one with the same digest exists, then maps the artifact to it.
+func (s *ContentStore) Create(a Artifact) (ArtifactWriter, error) {
+	key, err := s.key(a)
+	if err != nil {
+		return nil, err
+	}
+	f, err := createStagingFile()
+	if err != nil {
+		return nil, err
+	}
+	return &contentWriter{store: s, key: key, file: f, hash: sha256.New()}, nil
+}
+
+// Delete removes the artifact's mapping, and its blob if no other artifact
+// is mapped to it
+func (s *ContentStore) Delete(a Artifact) error {
+	key, err := s.key(a)
+	if err != nil {
+		return err
+	}
+	orphan, err := s.store.DeleteArtifactDigest(key)
+	if err != nil {
+		return fmt.Errorf("error deleting digest of %s: %w", key, err)
+	}
+	return s.deleteBlob(orphan)
+}
+
+// Versions lists the versions of a slug with a digest mapping
+func (s *ContentStore) Versions(kind, slug string) ([]string, error) {
+	a := Artifact{Type: kind, Slug: slug, Version: "0"}
+	if _, err := s.key(a); err != nil {
+		return nil, err
+	}
+	prefix := kind + "/" + slug + "."
+	digests, err := s.store.ListArtifactDigests(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]string, 0, len(digests))
+	for key := range digests {
+		version := strings.TrimPrefix(key, prefix)
+		if strings.HasSuffix(version, ".zip") {
+			versions = append(versions, strings.TrimSuffix(version, ".zip"))
+		}
+	}
+	return versions, nil
+}
+
+// PresignGet presigns the artifact's blob if the underlying store can
+func (s *ContentStore) PresignGet(a Artifact, expiry time.Duration) (string, error) {
+	presigner, ok := s.blobs.(ArtifactPresigner)
+	if !ok {
+		return "", errors.New("the blob store cannot presign URLs")
+	}
+	blob, err := s.blob(a)
+	if err != nil {
+		return "", err
+	}
+	return presigner.PresignGet(blob, expiry)
+}
+
+// Partials lists the partial files of the blob store, if it has any
+func (s *ContentStore) Partials() ([]string, error) {
+	if lister, ok := s.blobs.(partialLister); ok {
+		return lister.Partials()
+	}
+	return nil, nil
+}
+
+// deleteBlob deletes the blob of an orphaned digest, if there is one
+func (s *ContentStore) deleteBlob(orphan string) error {
+	if orphan == "" {
+		return nil
+	}
+	if err := s.blobs.Delete(blobArtifact(orphan)); err != nil {
+		return fmt.Errorf("error deleting blob %s: %w", orphan, err)
+	}
+	return nil
+}
+
+type contentWriter struct {
+	store *ContentStore
+	key   string
+	file  *os.File
+	hash  hash.Hash
+}
+
+func (w *contentWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+func (w *contentWriter) Commit() error {
+	defer w.Abort()
+
+	digest := hex.EncodeToString(w.hash.Sum(nil))
+	blob := blobArtifact(digest)
+	if err := w.ensureBlob(blob); err != nil {
+		return err
+	}
+
+	orphan, err := w.store.store.SetArtifactDigest(w.key, digest)
+	if err != nil {
+		return fmt.Errorf("error storing digest of %s: %w", w.key, err)
+	}
+	// A concurrent Delete of the last other artifact with this content may
+	// have removed the blob before the mapping referenced it
+	if err := w.ensureBlob(blob); err != nil {
+		return err
+	}
+	return w.store.deleteBlob(orphan)
+}
+
+// ensureBlob stores the temporary file as the blob unless it exists
+func (w *contentWriter) ensureBlob(blob Artifact) error {
+	_, err := w.store.blobs.Stat(blob)
+	if errors.Is(err, ErrArtifactNotFound) {
+		return w.storeBlob(blob)
+	}
+	if err != nil {
+		return fmt.Errorf("error checking blob %s: %w", blob.Version, err)
+	}
+	return nil
+}
+
+// storeBlob copies the temporary file to the blob
+func (w *contentWriter) storeBlob(blob Artifact) error {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error reading file: %w", err)
+	}
+	out, err := w.store.blobs.Create(blob)
+	if err != nil {
+		return fmt.Errorf("error creating blob: %w", err)
+	}
+	if _, err := io.Copy(out, w.file); err != nil {
+		out.Abort()
+		return fmt.Errorf("error writing blob: %w", err)
+	}
+	return out.Commit()
+}
+
+func (w *contentWriter) Abort() error {
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func storeArtifact(t *testing.T, artifacts ArtifactStore, a Artifact, content string) {
+	w, err := artifacts.Create(a)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit())
+}
+
+// blobFiles lists the blob files below dir
+func blobFiles(t *testing.T, dir string) []string {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, d.Name())
+		}
+		return err
+	})
+	require.NoError(t, err)
+	return files
+}
+
+func digestOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestContentStoreDeduplicates(t *testing.T) {
+	paths := PathsConfig{BlobsDir: t.TempDir()}
+	store := NewMemoryStore()
+	artifacts := NewContentStore(NewFileArtifactStore(paths), store)
+
+	first := Artifact{Type: "plugin", Slug: "akismet", Version: "5.2"}
+	second := Artifact{Type: "plugin", Slug: "akismet", Version: "5.2.1"}
+	storeArtifact(t, artifacts, first, "same zip")
+	storeArtifact(t, artifacts, second, "same zip")
+	storeArtifact(t, artifacts, Artifact{Type: "theme", Slug: "twentytwentyfour", Version: "1.0"}, "theme zip")
+	assert.ElementsMatch(t, []string{digestOf("same zip"), digestOf("theme zip")}, blobFiles(t, paths.BlobsDir))
+
+	info, err := artifacts.Stat(second)
+	require.NoError(t, err)
+	assert.Equal(t, digestOf("same zip"), info.Digest)
+	versions, err := artifacts.Versions("plugin", "akismet")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"5.2", "5.2.1"}, versions)
+
+	// The blob is kept while an artifact still maps to it
+	require.NoError(t, artifacts.Delete(first))
+	assert.Len(t, blobFiles(t, paths.BlobsDir), 2)
+	_, err = artifacts.Stat(first)
+	assert.ErrorIs(t, err, ErrArtifactNotFound)
+
+	// Replacing the content of the last artifact drops the old blob
+	storeArtifact(t, artifacts, second, "rebuilt zip")
+	assert.ElementsMatch(t, []string{digestOf("rebuilt zip"), digestOf("theme zip")}, blobFiles(t, paths.BlobsDir))
+
+	// An artifact whose blob is gone counts as missing
+	require.NoError(t, NewFileArtifactStore(paths).Delete(blobArtifact(digestOf("rebuilt zip"))))
+	_, err = artifacts.Stat(second)
+	assert.ErrorIs(t, err, ErrArtifactNotFound)
+
+	_, err = artifacts.Create(blobArtifact(digestOf("theme zip")))
+	assert.Error(t, err)
+}
+
+// racingStore runs beforeSet ahead of the next SetArtifactDigest, as a
+// concurrent caller would
+type racingStore struct {
+	*MemoryStore
+	beforeSet func()
+}
+
+func (s *racingStore) SetArtifactDigest(key, digest string) (string, error) {
+	if f := s.beforeSet; f != nil {
+		s.beforeSet = nil
+		f()
+	}
+	return s.MemoryStore.SetArtifactDigest(key, digest)
+}
+
+func TestContentStoreCommitRacingDelete(t *testing.T) {
+	paths := PathsConfig{BlobsDir: t.TempDir()}
+	store := &racingStore{MemoryStore: NewMemoryStore()}
+	artifacts := NewContentStore(NewFileArtifactStore(paths), store)
+
+	first := Artifact{Type: "plugin", Slug: "akismet", Version: "5.2"}
+	second := Artifact{Type: "plugin", Slug: "akismet", Version: "5.2.1"}
+	storeArtifact(t, artifacts, first, "same zip")
+
+	// The only other artifact with the content is deleted after the commit
+	// found its blob but before it is mapped
+	store.beforeSet = func() { require.NoError(t, artifacts.Delete(first)) }
+	storeArtifact(t, artifacts, second, "same zip")
+
+	info, err := artifacts.Stat(second)
+	require.NoError(t, err)
+	assert.Equal(t, digestOf("same zip"), info.Digest)
+	assert.Equal(t, []string{digestOf("same zip")}, blobFiles(t, paths.BlobsDir))
+}
+
+func TestContentStoreServesDownloads(t *testing.T) {
+	setupProxyConfig(t, "http://127.0.0.1:1")
+	cfg.Proxy.Enabled = false
+	cfg.Paths.BlobsDir = t.TempDir()
+	store := NewMemoryStore()
+	artifacts := NewContentStore(NewFileArtifactStore(cfg.Paths), store)
+	storeArtifact(t, artifacts, Artifact{Type: "core", Version: "6.4.2", Locale: "de_DE"}, "wordpress de_DE")
+	router := NewServer(store, artifacts).setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/core/6.4.2-de_DE.zip", nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+	assert.Equal(t, "wordpress de_DE", w.Body.String())
+	etag := w.Header().Get("ETag")
+	assert.Equal(t, `"sha256:`+digestOf("wordpress de_DE")+`"`, etag)
+
+	w = httptest.NewRecorder()
+	req.Header.Set("If-None-Match", etag)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 304, w.Code)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultLocale = "en_US"
+
+// CoreOffer is one entry of the "offers" list returned by the core
+// version-check API
+type CoreOffer struct {
+	Response       string       `json:"response"`
+	Download       string       `json:"download"`
+	Locale         string       `json:"locale"`
+	Packages       CorePackages `json:"packages"`
+	Current        string       `json:"current"`
+	Version        string       `json:"version"`
+	PHPVersion     string       `json:"php_version"`
+	MySQLVersion   string       `json:"mysql_version"`
+	NewBundled     string       `json:"new_bundled"`
+	PartialVersion interface{}  `json:"partial_version"`
+	NewFiles       interface{}  `json:"new_files,omitempty"`
+}
+
+// CorePackages lists the download packages of a core offer. WordPress
+// expects false rather than an empty string for missing packages.
+type CorePackages struct {
+	Full       string      `json:"full"`
+	NoContent  interface{} `json:"no_content"`
+	NewBundled interface{} `json:"new_bundled"`
+	Partial    interface{} `json:"partial"`
+	Rollback   interface{} `json:"rollback"`
+}
+
+// CoreVersionCheckResponse is the body of /core/version-check/1.7/
+type CoreVersionCheckResponse struct {
+	Offers       []CoreOffer   `json:"offers"`
+	Translations []interface{} `json:"translations"`
+}
+
+// coreUpdateRequest holds the client details sent with a core update check
+type coreUpdateRequest struct {
+	Version string
+	PHP     string
+	MySQL   string
+	Locale  string
+}
+
+// coreUpdateRequestFromQuery reads the client details from the query string
+// WordPress sends to the version-check API
+func coreUpdateRequestFromQuery(c *gin.Context) coreUpdateRequest {
+	return coreUpdateRequest{
+		Version: c.Query("version"),
+		PHP:     c.Query("php"),
+		MySQL:   c.Query("mysql"),
+		Locale:  c.Query("locale"),
+	}
+}
+
+// buildCoreOffers computes the offers for a client from the stored core
+// versions. The first offer is the newest release, answered with "latest"
+// if the client already runs it and "upgrade" otherwise. It is followed by
+// the "autoupdate" offers: the newest release itself and the newest release
+// of the client's current branch, each only if it is newer than the client's
+// version and its PHP and MySQL requirements are met.
+func buildCoreOffers(versions []CoreVersion, req coreUpdateRequest) []CoreOffer {
+	versions = coreVersionsForLocale(versions, req.Locale)
+	if len(versions) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return CompareVersions(versions[i].Version, versions[j].Version) > 0
+	})
+
+	latest := versions[0]
+	response := "upgrade"
+	if req.Version != "" && CompareVersions(req.Version, latest.Version) >= 0 {
+		response = "latest"
+	}
+	offers := []CoreOffer{newCoreOffer(latest, response)}
+
+	isAutoUpdate := func(v CoreVersion) bool {
+		return req.Version != "" &&
+			CompareVersions(v.Version, req.Version) > 0 &&
+			meetsRequirement(req.PHP, v.PHPVersion) &&
+			meetsRequirement(req.MySQL, v.MySQLVersion)
+	}
+
+	if isAutoUpdate(latest) {
+		offer := newCoreOffer(latest, "autoupdate")
+		offer.NewFiles = true
+		offers = append(offers, offer)
+	}
+
+	branch := versionBranch(req.Version)
+	for _, v := range versions {
+		if versionBranch(v.Version) != branch {
+			continue
+		}
+		if v.Version != latest.Version && isAutoUpdate(v) {
+			offers = append(offers, newCoreOffer(v, "autoupdate"))
+		}
+		break
+	}
+
+	return offers
+}
+
+// coreVersionsForLocale returns the versions built for locale, falling back
+// to the en_US builds if there are none
+func coreVersionsForLocale(versions []CoreVersion, locale string) []CoreVersion {
+	if locale == "" {
+		locale = defaultLocale
+	}
+	for _, l := range []string{locale, defaultLocale} {
+		var matching []CoreVersion
+		for _, v := range versions {
+			if v.Locale == l || (v.Locale == "" && l == defaultLocale) {
+				matching = append(matching, v)
+			}
+		}
+		if len(matching) > 0 {
+			return matching
+		}
+	}
+	return nil
+}
+
+func newCoreOffer(v CoreVersion, response string) CoreOffer {
+	locale := v.Locale
+	if locale == "" {
+		locale = defaultLocale
+	}
+	current := v.Current
+	if current == "" {
+		current = v.Version
+	}
+	return CoreOffer{
+		Response: response,
+		Download: v.Package,
+		Locale:   locale,
+		Packages: CorePackages{
+			Full:       v.Package,
+			NoContent:  false,
+			NewBundled: false,
+			Partial:    false,
+			Rollback:   false,
+		},
+		Current:        current,
+		Version:        v.Version,
+		PHPVersion:     v.PHPVersion,
+		MySQLVersion:   v.MySQLVersion,
+		NewBundled:     v.NewBundled,
+		PartialVersion: v.PartialVersion,
+	}
+}
+
+// meetsRequirement reports whether the client's version satisfies required.
+// Unknown values on either side are treated as satisfied.
+func meetsRequirement(have, required string) bool {
+	if have == "" || required == "" {
+		return true
+	}
+	return CompareVersions(have, required) >= 0
+}
+
+// versionBranch returns the major branch of a version, "6.3" for "6.3.2"
+func versionBranch(v string) string {
+	if v == "" {
+		return ""
+	}
+	parts := strings.SplitN(canonicalizeVersion(v), ".", 3)
+	if len(parts) < 2 {
+		return parts[0]
+	}
+	return parts[0] + "." + parts[1]
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Crawl modes. A full crawl walks every page of a directory; a delta crawl
+// walks the recently updated entries until it reaches ones older than the
+// last completed crawl.
+const (
+	CrawlFull  = "full"
+	CrawlDelta = "delta"
+)
+
+// CrawlCursor is the progress of a directory crawl, saved in the metadata
+// store after every page so an interrupted crawl resumes where it stopped
+type CrawlCursor struct {
+	// Mode is the mode of the crawl in progress
+	Mode string `json:"mode,omitempty"`
+	// Page is the last page stored by the crawl in progress, 0 if none is
+	Page int `json:"page"`
+	// Pages is the page count reported by the directory
+	Pages     int       `json:"pages"`
+	StartedAt time.Time `json:"started_at"`
+	// SyncedAt is when the last completed crawl started; entries updated
+	// before it are already stored
+	SyncedAt time.Time `json:"synced_at"`
+	// Revision is the last SVN revision read by a changelog sync
+	Revision int `json:"revision,omitempty"`
+}
+
+// crawlDirectory describes a WordPress.org directory the crawler can walk
+type crawlDirectory struct {
+	// name is the cursor name of the directory
+	name string
+	// kind is the DownloadItem type of its entries
+	kind string
+	// apiURL returns the query URL the page parameters are added to
+	apiURL func() string
+	// storePage stores the entries of a fetched page and returns the page
+	// info and the oldest last update time on the page
+	storePage func(store MetadataStore, body []byte) (QueryInfo, time.Time, error)
+	// changelogURL returns the Trac log URL of the directory's SVN repository
+	changelogURL func() string
+	// infoAction is the API action returning the record of a single slug
+	infoAction string
+	// storeInfo stores the response of infoAction
+	storeInfo func(store MetadataStore, body []byte) error
+	// scope returns the scope rules of the directory
+	scope func() ScopeRules
+}
+
+var pluginDirectory = crawlDirectory{
+	name:         "plugins",
+	kind:         "plugin",
+	apiURL:       func() string { return cfg.Updater.PluginsAPIURL },
+	storePage:    storePluginPage,
+	changelogURL: func() string { return cfg.Updater.PluginsChangelogURL },
+	infoAction:   "plugin_information",
+	storeInfo:    storePluginInformation,
+	scope:        func() ScopeRules { return cfg.Scope.Plugins },
+}
+
+var themeDirectory = crawlDirectory{
+	name:         "themes",
+	kind:         "theme",
+	apiURL:       func() string { return cfg.Updater.ThemesAPIURL },
+	storePage:    storeThemePage,
+	changelogURL: func() string { return cfg.Updater.ThemesChangelogURL },
+	infoAction:   "theme_information",
+	storeInfo:    storeThemeInformation,
+	scope:        func() ScopeRules { return cfg.Scope.Themes },
+}
+
+// Crawler walks the paginated WordPress.org directories into the store
+type Crawler struct {
+	store  MetadataStore
+	client *http.Client
+}
+
+func NewCrawler(store MetadataStore) *Crawler {
+	return &Crawler{
+		store:  store,
+		client: &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+// Crawl walks dir in the given mode, waiting cfg.Crawler.RequestInterval
+// between requests. An interrupted crawl is resumed after its last stored
+// page, unless a full crawl is asked for and a delta crawl was interrupted.
+func (cr *Crawler) Crawl(dir crawlDirectory, mode string) error {
+	cursor, err := cr.store.GetCrawlCursor(dir.name)
+	if err != nil {
+		return fmt.Errorf("error reading %s crawl cursor: %w", dir.name, err)
+	}
+
+	if cursor.Page == 0 || (mode == CrawlFull && cursor.Mode != CrawlFull) {
+		cursor.Mode = mode
+		cursor.Page = 0
+		cursor.Pages = 0
+		cursor.StartedAt = time.Now().UTC()
+		log.Printf("Starting %s crawl of %s", cursor.Mode, dir.name)
+	} else {
+		log.Printf("Resuming %s crawl of %s at page %d of %d", cursor.Mode, dir.name, cursor.Page+1, cursor.Pages)
+	}
+
+	firstPage := cursor.Page + 1
+	for page := firstPage; ; page++ {
+		if page > firstPage {
+			time.Sleep(cfg.Crawler.RequestInterval)
+		}
+
+		body, err := cr.fetch(crawlPageURL(dir.apiURL(), cursor.Mode, page))
+		if err != nil {
+			return fmt.Errorf("error fetching %s page %d: %w", dir.name, page, err)
+		}
+		info, oldest, err := dir.storePage(cr.store, body)
+		if err != nil {
+			return fmt.Errorf("error storing %s page %d: %w", dir.name, page, err)
+		}
+
+		cursor.Page = page
+		cursor.Pages = info.Pages
+		if err := cr.store.SetCrawlCursor(dir.name, *cursor); err != nil {
+			return fmt.Errorf("error saving %s crawl cursor: %w", dir.name, err)
+		}
+		log.Printf("Crawled %s page %d of %d", dir.name, page, info.Pages)
+
+		if page >= info.Pages {
+			break
+		}
+		if cursor.Mode == CrawlDelta && !cursor.SyncedAt.IsZero() && oldest.Before(cursor.SyncedAt) {
+			break
+		}
+	}
+
+	completed := CrawlCursor{SyncedAt: cursor.StartedAt}
+	if err := cr.store.SetCrawlCursor(dir.name, completed); err != nil {
+		return fmt.Errorf("error saving %s crawl cursor: %w", dir.name, err)
+	}
+	log.Printf("Finished %s crawl of %s", cursor.Mode, dir.name)
+	return nil
+}
+
+func (cr *Crawler) fetch(pageURL string) ([]byte, error) {
+	resp, err := cr.client.Get(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotInDirectory
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// crawlPageURL adds the page, page size and, for delta crawls, the
+// browse=updated ordering to a directory query URL
+func crawlPageURL(apiURL, mode string, page int) string {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return apiURL
+	}
+	q := u.Query()
+	q.Set("request[page]", strconv.Itoa(page))
+	q.Set("request[per_page]", strconv.Itoa(cfg.Crawler.PerPage))
+	if mode == CrawlDelta {
+		q.Set("request[browse]", "updated")
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// storePluginPage stores every plugin of a query_plugins page
+func storePluginPage(store MetadataStore, body []byte) (QueryInfo, time.Time, error) {
+	var pluginData struct {
+		Info    QueryInfo    `json:"info"`
+		Plugins []PluginInfo `json:"plugins"`
+	}
+	err := json.Unmarshal(body, &pluginData)
+	if err != nil {
+		return QueryInfo{}, time.Time{}, fmt.Errorf("error unmarshalling WordPress plugins: %w", err)
+	}
+
+	var oldest time.Time
+	for i, info := range pluginData.Plugins {
+		if err := storePluginInfo(store, info); err != nil {
+			return QueryInfo{}, time.Time{}, err
+		}
+		updated := parseWPOrgTime(info.LastUpdated)
+		if i == 0 || updated.Before(oldest) {
+			oldest = updated
+		}
+	}
+	return pluginData.Info, oldest, nil
+}
+
+// storePluginInfo stores the full plugin record, adds the releases of its
+// history to the plugin's versions if they are new and prunes the expired
+// ones. Plugins out of scope are skipped; pinned ones are stored at their
+// newest version within the pin.
+func storePluginInfo(store MetadataStore, info PluginInfo) error {
+	if !cfg.Scope.Plugins.Allows(info.Slug) {
+		return nil
+	}
+	if !cfg.Scope.Plugins.AllowsVersion(info.Slug, info.Version) {
+		version, ok := newestAllowedVersion(cfg.Scope.Plugins, info.Slug, info.Versions)
+		if !ok {
+			log.Printf("No version of plugin %s within its pin %s", info.Slug, cfg.Scope.Plugins.Pins[info.Slug])
+			return nil
+		}
+		info.Version = version
+		info.DownloadLink = info.Versions[version]
+		info.LastUpdated = ""
+	}
+
+	err := store.SetPluginInfo(info)
+	if err != nil {
+		return fmt.Errorf("error storing plugin information for %s: %w", info.Slug, err)
+	}
+
+	existingVersions, err := store.GetPluginVersions(info.Slug)
+	if err != nil {
+		return fmt.Errorf("error fetching existing plugin versions for %s: %w", info.Slug, err)
+	}
+	existing := make(map[string]bool)
+	for _, v := range existingVersions {
+		existing[v.NewVersion] = true
+	}
+
+	var added []PluginVersion
+	for _, plugin := range pluginHistory(info) {
+		if !existing[plugin.NewVersion] {
+			log.Printf("Adding new plugin version: %s %s", plugin.Slug, plugin.NewVersion)
+			added = append(added, plugin)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	err = store.SetPluginVersions(info.Slug, added)
+	if err != nil {
+		return fmt.Errorf("error adding new plugin version: %w", err)
+	}
+	_, err = prunePluginVersions(store, info.Slug)
+	return err
+}
+
+// storeThemePage stores every theme of a query_themes page
+func storeThemePage(store MetadataStore, body []byte) (QueryInfo, time.Time, error) {
+	var themeData struct {
+		Info   QueryInfo   `json:"info"`
+		Themes []ThemeInfo `json:"themes"`
+	}
+	err := json.Unmarshal(body, &themeData)
+	if err != nil {
+		return QueryInfo{}, time.Time{}, fmt.Errorf("error unmarshalling WordPress themes: %w", err)
+	}
+
+	var oldest time.Time
+	for i, info := range themeData.Themes {
+		if err := storeThemeInfo(store, info); err != nil {
+			return QueryInfo{}, time.Time{}, err
+		}
+		updated := parseWPOrgTime(themeUpdatedTime(info))
+		if i == 0 || updated.Before(oldest) {
+			oldest = updated
+		}
+	}
+	return themeData.Info, oldest, nil
+}
+
+// storeThemeInfo stores the full theme record, adds the releases of its
+// history to the theme's versions if they are new and prunes the expired
+// ones. Themes out of scope are skipped; pinned ones are stored at their
+// newest version within the pin.
+func storeThemeInfo(store MetadataStore, info ThemeInfo) error {
+	if !cfg.Scope.Themes.Allows(info.Slug) {
+		return nil
+	}
+	if !cfg.Scope.Themes.AllowsVersion(info.Slug, info.Version) {
+		version, ok := newestAllowedVersion(cfg.Scope.Themes, info.Slug, info.Versions)
+		if !ok {
+			log.Printf("No version of theme %s within its pin %s", info.Slug, cfg.Scope.Themes.Pins[info.Slug])
+			return nil
+		}
+		info.Version = version
+		info.DownloadLink = info.Versions[version]
+		info.LastUpdated, info.LastUpdatedTime = "", ""
+	}
+
+	err := store.SetThemeInfo(info)
+	if err != nil {
+		return fmt.Errorf("error storing theme information for %s: %w", info.Slug, err)
+	}
+
+	existingVersions, err := store.GetThemeVersions(info.Slug)
+	if err != nil {
+		return fmt.Errorf("error fetching existing theme versions for %s: %w", info.Slug, err)
+	}
+	existing := make(map[string]bool)
+	for _, v := range existingVersions {
+		existing[v.NewVersion] = true
+	}
+
+	var added []ThemeVersion
+	for _, theme := range themeHistory(info) {
+		if !existing[theme.NewVersion] {
+			log.Printf("Adding new theme version: %s %s", theme.Theme, theme.NewVersion)
+			added = append(added, theme)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	err = store.SetThemeVersions(info.Slug, added)
+	if err != nil {
+		return fmt.Errorf("error adding new theme version: %w", err)
+	}
+	_, err = pruneThemeVersions(store, info.Slug)
+	return err
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDirectory serves query_plugins or query_themes pages, the newest entry
+// first. Pages listed in failing answer 500 once.
+type fakeDirectory struct {
+	mu      sync.Mutex
+	key     string
+	entries []interface{}
+	failing map[int]bool
+	fetched []int
+}
+
+func (d *fakeDirectory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("request[page]"))
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("request[per_page]"))
+	if d.failing[page] {
+		delete(d.failing, page)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	d.fetched = append(d.fetched, page)
+
+	start := (page - 1) * perPage
+	end := start + perPage
+	if end > len(d.entries) {
+		end = len(d.entries)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"info": QueryInfo{
+			Page:    page,
+			Pages:   (len(d.entries) + perPage - 1) / perPage,
+			Results: len(d.entries),
+		},
+		d.key: d.entries[start:end],
+	})
+}
+
+func newFakePluginDirectory(count int) *fakeDirectory {
+	d := &fakeDirectory{key: "plugins", failing: make(map[int]bool)}
+	for i := 0; i < count; i++ {
+		d.entries = append(d.entries, PluginInfo{
+			Name:         fmt.Sprintf("Plugin %d", i),
+			Slug:         fmt.Sprintf("plugin-%d", i),
+			Version:      "1.0",
+			LastUpdated:  fmt.Sprintf("2023-01-%02d 1:00pm GMT", 28-i),
+			DownloadLink: fmt.Sprintf("https://downloads.wordpress.org/plugin/plugin-%d.1.0.zip", i),
+		})
+	}
+	return d
+}
+
+func newFakeThemeDirectory(count int) *fakeDirectory {
+	d := &fakeDirectory{key: "themes", failing: make(map[int]bool)}
+	for i := 0; i < count; i++ {
+		d.entries = append(d.entries, ThemeInfo{
+			Name:         fmt.Sprintf("Theme %d", i),
+			Slug:         fmt.Sprintf("theme-%d", i),
+			Version:      "1.0",
+			LastUpdated:  fmt.Sprintf("2023-01-%02d", 28-i),
+			DownloadLink: fmt.Sprintf("https://downloads.wordpress.org/theme/theme-%d.1.0.zip", i),
+		})
+	}
+	return d
+}
+
+func setupCrawlerConfig(t *testing.T, apiURL string) {
+	previous := cfg
+	t.Cleanup(func() { cfg = previous })
+
+	cfg = DefaultConfig()
+	cfg.Updater.PluginsAPIURL = apiURL + "/plugins/info/1.2/?action=query_plugins"
+	cfg.Updater.ThemesAPIURL = apiURL + "/themes/info/1.1/?action=query_themes"
+	cfg.Crawler.PerPage = 2
+	cfg.Crawler.RequestInterval = 0
+}
+
+func TestCrawlWalksEveryPage(t *testing.T) {
+	dir := newFakePluginDirectory(5)
+	upstream := httptest.NewServer(dir)
+	defer upstream.Close()
+	setupCrawlerConfig(t, upstream.URL)
+
+	store := NewMemoryStore()
+	require.NoError(t, NewCrawler(store).Crawl(pluginDirectory, CrawlFull))
+
+	assert.Equal(t, []int{1, 2, 3}, dir.fetched)
+	summaries, err := store.ListPluginSummaries()
+	require.NoError(t, err)
+	assert.Len(t, summaries, 5)
+
+	latest, err := store.GetLatestPluginVersion("plugin-4")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", latest.NewVersion)
+
+	cursor, err := store.GetCrawlCursor("plugins")
+	require.NoError(t, err)
+	assert.Zero(t, cursor.Page)
+	assert.False(t, cursor.SyncedAt.IsZero())
+}
+
+func TestCrawlResumesFromCursor(t *testing.T) {
+	dir := newFakePluginDirectory(5)
+	dir.failing[2] = true
+	upstream := httptest.NewServer(dir)
+	defer upstream.Close()
+	setupCrawlerConfig(t, upstream.URL)
+
+	store := NewMemoryStore()
+	assert.Error(t, NewCrawler(store).Crawl(pluginDirectory, CrawlFull))
+
+	cursor, err := store.GetCrawlCursor("plugins")
+	require.NoError(t, err)
+	assert.Equal(t, CrawlFull, cursor.Mode)
+	assert.Equal(t, 1, cursor.Page)
+	assert.Equal(t, 3, cursor.Pages)
+
+	// A delta run resumes the interrupted full crawl
+	require.NoError(t, NewCrawler(store).Crawl(pluginDirectory, CrawlDelta))
+	assert.Equal(t, []int{1, 2, 3}, dir.fetched)
+
+	summaries, err := store.ListPluginSummaries()
+	require.NoError(t, err)
+	assert.Len(t, summaries, 5)
+}
+
+func TestDeltaCrawlStopsAtSyncedEntries(t *testing.T) {
+	dir := newFakePluginDirectory(5)
+	upstream := httptest.NewServer(dir)
+	defer upstream.Close()
+	setupCrawlerConfig(t, upstream.URL)
+
+	store := NewMemoryStore()
+	require.NoError(t, store.SetCrawlCursor("plugins", CrawlCursor{
+		SyncedAt: parseWPOrgTime("2023-01-27 1:30pm GMT"),
+	}))
+
+	require.NoError(t, NewCrawler(store).Crawl(pluginDirectory, CrawlDelta))
+	assert.Equal(t, []int{1}, dir.fetched)
+}
+
+func TestThemeCrawlContinuesAfterRestart(t *testing.T) {
+	dir := newFakeThemeDirectory(7)
+	dir.failing[3] = true
+	upstream := httptest.NewServer(dir)
+	defer upstream.Close()
+	setupCrawlerConfig(t, upstream.URL)
+
+	store := NewMemoryStore()
+	assert.Error(t, NewCrawler(store).Crawl(themeDirectory, CrawlDelta))
+
+	cursor, err := store.GetCrawlCursor("themes")
+	require.NoError(t, err)
+	assert.Equal(t, 2, cursor.Page)
+	assert.Equal(t, 4, cursor.Pages)
+
+	// A new crawler, as after a restart, continues with page 3
+	require.NoError(t, NewCrawler(store).Crawl(themeDirectory, CrawlDelta))
+	assert.Equal(t, []int{1, 2, 3, 4}, dir.fetched)
+
+	summaries, err := store.ListThemeSummaries()
+	require.NoError(t, err)
+	assert.Len(t, summaries, 7)
+
+	latest, err := store.GetLatestThemeVersion("theme-6")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", latest.NewVersion)
+
+	cursor, err = store.GetCrawlCursor("themes")
+	require.NoError(t, err)
+	assert.Zero(t, cursor.Page)
+	assert.False(t, cursor.SyncedAt.IsZero())
+}
+
+func TestQueryInfoAcceptsQuotedCounts(t *testing.T) {
+	var info QueryInfo
+	require.NoError(t, json.Unmarshal([]byte(`{"page":2,"pages":"10","results":"245"}`), &info))
+	assert.Equal(t, QueryInfo{Page: 2, Pages: 10, Results: 245}, info)
+}
+
+func TestCrawlPageURL(t *testing.T) {
+	setupCrawlerConfig(t, "https://api.wordpress.org")
+
+	u := crawlPageURL(cfg.Updater.PluginsAPIURL, CrawlDelta, 3)
+	assert.Contains(t, u, "request%5Bpage%5D=3")
+	assert.Contains(t, u, "request%5Bper_page%5D=2")
+	assert.Contains(t, u, "request%5Bbrowse%5D=updated")
+	assert.NotContains(t, crawlPageURL(cfg.Updater.PluginsAPIURL, CrawlFull, 1), "browse")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Demand is a plugin or theme slug a client asked about that the mirror
+// does not track yet
+type Demand struct {
+	Slug        string    `json:"slug"`
+	RequestedAt time.Time `json:"requested_at"`
+	// CheckedAt is when the slug was last looked up upstream
+	CheckedAt time.Time `json:"checked_at,omitempty"`
+	// Unavailable is set when the upstream directory does not serve the
+	// slug, e.g. for commercial plugins
+	Unavailable bool `json:"unavailable,omitempty"`
+}
+
+// recordPluginDemand records an unknown in-scope plugin as demanded
+func (s *Server) recordPluginDemand(pluginFile string) {
+	slug := pluginSlug(pluginFile)
+	if !cfg.Scope.Plugins.Allows(slug) {
+		return
+	}
+	if err := s.store.RecordDemand(pluginDirectory.kind, slug); err != nil {
+		log.Printf("Error recording demand for plugin %s: %v", slug, err)
+	}
+}
+
+// recordThemeDemand records an unknown in-scope theme as demanded
+func (s *Server) recordThemeDemand(themeSlug string) {
+	if !cfg.Scope.Themes.Allows(themeSlug) {
+		return
+	}
+	if err := s.store.RecordDemand(themeDirectory.kind, themeSlug); err != nil {
+		log.Printf("Error recording demand for theme %s: %v", themeSlug, err)
+	}
+}
+
+// DemandFetcher mirrors the demanded plugins and themes from upstream
+type DemandFetcher struct {
+	store MetadataStore
+}
+
+func NewDemandFetcher(store MetadataStore) *DemandFetcher {
+	return &DemandFetcher{store: store}
+}
+
+// Run fetches the demanded slugs every discovery interval, forever
+func (f *DemandFetcher) Run() {
+	for {
+		for _, dir := range []crawlDirectory{pluginDirectory, themeDirectory} {
+			if err := f.fetchDemanded(dir); err != nil {
+				log.Printf("Error fetching demanded %s: %v", dir.name, err)
+			}
+		}
+		time.Sleep(cfg.Discovery.Interval)
+	}
+}
+
+// fetchDemanded fetches the record of every demanded slug of dir and queues
+// the download of its newest in-scope version. Slugs upstream does not
+// serve are marked unavailable and retried after cfg.Discovery.RetryAfter.
+func (f *DemandFetcher) fetchDemanded(dir crawlDirectory) error {
+	demands, err := f.store.ListDemands(dir.kind)
+	if err != nil {
+		return fmt.Errorf("error listing demands: %w", err)
+	}
+
+	crawler := NewCrawler(f.store)
+	fetched := 0
+	for _, d := range demands {
+		if d.Unavailable && time.Since(d.CheckedAt) < cfg.Discovery.RetryAfter {
+			continue
+		}
+		if !dir.scope().Allows(d.Slug) {
+			if err := f.store.DeleteDemand(dir.kind, d.Slug); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if fetched > 0 {
+			time.Sleep(cfg.Crawler.RequestInterval)
+		}
+		fetched++
+
+		err := crawler.syncSlug(dir, d.Slug)
+		if err == nil {
+			err = f.queueDownload(dir, d.Slug)
+		}
+		if errors.Is(err, errNotInDirectory) {
+			log.Printf("Demanded %s %s is not available upstream", dir.kind, d.Slug)
+			d.Unavailable = true
+			d.CheckedAt = time.Now().UTC()
+			if err := f.store.SetDemand(dir.kind, d); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Error fetching demanded %s %s: %v", dir.kind, d.Slug, err)
+			continue
+		}
+
+		log.Printf("Now mirroring demanded %s %s", dir.kind, d.Slug)
+		if err := f.store.DeleteDemand(dir.kind, d.Slug); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueDownload queues the newest in-scope version of a freshly fetched
+// slug, returning errNotInDirectory if there is none, e.g. because of a pin
+func (f *DemandFetcher) queueDownload(dir crawlDirectory, slug string) error {
+	item := DownloadItem{Type: dir.kind, Slug: slug}
+	switch dir.kind {
+	case pluginDirectory.kind:
+		latest, err := latestAllowedPluginVersion(f.store, slug)
+		if errors.Is(err, ErrNoPluginVersions) {
+			return errNotInDirectory
+		}
+		if err != nil {
+			return err
+		}
+		item.Version, item.URL = latest.NewVersion, latest.Package
+	default:
+		latest, err := latestAllowedThemeVersion(f.store, slug)
+		if errors.Is(err, ErrNoThemeVersions) {
+			return errNotInDirectory
+		}
+		if err != nil {
+			return err
+		}
+		item.Version, item.URL = latest.NewVersion, latest.Package
+	}
+	return f.store.PushDownload(item)
+}
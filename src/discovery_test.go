@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateCheckRecordsDemand(t *testing.T) {
+	previous := cfg
+	t.Cleanup(func() { cfg = previous })
+	cfg = DefaultConfig()
+	cfg.Scope.Plugins.Exclude = []string{"private-*"}
+
+	store := NewMemoryStore()
+	seedDummyData(store)
+	router := NewServer(store, NewFileArtifactStore(cfg.Paths)).setupRouter()
+
+	plugins := `{"plugins":{"akismet/akismet.php":{"Version":"5.0"},"new-plugin/new-plugin.php":{"Version":"1.0"},"private-plugin/private-plugin.php":{"Version":"1.0"}}}`
+	form := url.Values{"plugins": {plugins}}
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/plugins/update-check/1.1/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	}
+
+	demands, err := store.ListDemands("plugin")
+	require.NoError(t, err)
+	require.Len(t, demands, 1)
+	assert.Equal(t, "new-plugin", demands[0].Slug)
+	assert.False(t, demands[0].RequestedAt.IsZero())
+}
+
+func TestFetchDemanded(t *testing.T) {
+	trac := &fakeTrac{}
+	upstream := httptest.NewServer(trac)
+	defer upstream.Close()
+	setupCrawlerConfig(t, upstream.URL)
+
+	store := NewMemoryStore()
+	require.NoError(t, store.RecordDemand("plugin", "new-plugin"))
+	require.NoError(t, store.RecordDemand("plugin", "closed-plugin"))
+
+	fetcher := NewDemandFetcher(store)
+	require.NoError(t, fetcher.fetchDemanded(pluginDirectory))
+	assert.Equal(t, []string{"closed-plugin", "new-plugin"}, trac.requested)
+
+	latest, err := store.GetLatestPluginVersion("new-plugin")
+	require.NoError(t, err)
+	assert.Equal(t, "2.0", latest.NewVersion)
+	item, err := store.PopDownload()
+	require.NoError(t, err)
+	assert.Equal(t, DownloadItem{Type: "plugin", Slug: "new-plugin", Version: "2.0", URL: latest.Package}, item)
+
+	// Unavailable slugs stay demanded but are left alone until RetryAfter
+	demands, err := store.ListDemands("plugin")
+	require.NoError(t, err)
+	require.Len(t, demands, 1)
+	assert.Equal(t, "closed-plugin", demands[0].Slug)
+	assert.True(t, demands[0].Unavailable)
+
+	require.NoError(t, fetcher.fetchDemanded(pluginDirectory))
+	assert.Len(t, trac.requested, 2)
+
+	demands[0].CheckedAt = time.Now().Add(-cfg.Discovery.RetryAfter)
+	require.NoError(t, store.SetDemand("plugin", demands[0]))
+	require.NoError(t, fetcher.fetchDemanded(pluginDirectory))
+	assert.Len(t, trac.requested, 3)
+}
@@ -1,53 +1,83 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
-	"strings"
 	"time"
-
-	"github.com/go-redis/redis/v8"
-)
-
-const (
-	publicFolder    = "./public"
-	downloadQueue   = "download_queue"
-	checkInterval   = 1 * time.Hour
 )
 
 type DownloadItem struct {
 	Type    string `json:"type"`
+	Slug    string `json:"slug,omitempty"`
 	Version string `json:"version"`
+	Locale  string `json:"locale,omitempty"`
 	URL     string `json:"url"`
+	// Attempts counts the earlier downloads of the item that failed
+	// verification
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// DownloadState records that an item was queued by the checker or abandoned
+// by the workers, so the checker does not queue it again meanwhile
+type DownloadState struct {
+	Key string `json:"key"`
+	// Abandoned is set once a worker gave up on the item
+	Abandoned bool      `json:"abandoned,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// downloadStateMaxAge is how long a queued or abandoned item is skipped.
+// Older states are dropped, so items lost from the queue or abandoned after
+// an upstream outage are tried again eventually.
+const downloadStateMaxAge = 24 * time.Hour
+
+// downloadKey returns the key of the download state of an item, the name of
+// the artifact it fetches
+func downloadKey(item DownloadItem) string {
+	return newArtifact(item).String()
 }
 
-func BackgroundDownloadChecker() {
+// DownloadChecker queues downloads for stored versions missing on disk and
+// prunes the releases beyond the retention limit
+type DownloadChecker struct {
+	store     MetadataStore
+	artifacts ArtifactStore
+}
+
+func NewDownloadChecker(store MetadataStore, artifacts ArtifactStore) *DownloadChecker {
+	return &DownloadChecker{store: store, artifacts: artifacts}
+}
+
+// Run checks for missing downloads every check interval, forever
+func (dc *DownloadChecker) Run() {
 	for {
-		err := checkAndQueueDownloads()
+		err := dc.applyRetention()
+		if err != nil {
+			fmt.Printf("Error applying retention: %v\n", err)
+		}
+		err = dc.checkAndQueueDownloads()
 		if err != nil {
 			fmt.Printf("Error in background job: %v\n", err)
 		}
-		time.Sleep(checkInterval)
+		time.Sleep(cfg.Checker.Interval)
 	}
 }
 
-func checkAndQueueDownloads() error {
+func (dc *DownloadChecker) checkAndQueueDownloads() error {
 	// Check core versions
-	coreVersions, err := GetCoreVersions()
+	coreVersions, err := dc.store.GetCoreVersions()
 	if err != nil {
 		return fmt.Errorf("error getting core versions: %w", err)
 	}
 
 	// Check plugin versions
-	pluginFiles, err := ListAllPluginFiles()
+	pluginFiles, err := dc.store.ListAllPluginFiles()
 	if err != nil {
 		return fmt.Errorf("error listing plugin files: %w", err)
 	}
 
 	// Check theme versions
-	themeSlugs, err := ListAllThemeSlugs()
+	themeSlugs, err := dc.store.ListAllThemeSlugs()
 	if err != nil {
 		return fmt.Errorf("error listing theme slugs: %w", err)
 	}
@@ -56,86 +86,122 @@ func checkAndQueueDownloads() error {
 
 	// Process core versions
 	for _, core := range coreVersions {
-		filename := fmt.Sprintf("wordpress-%s.zip", core.Version)
-		if !fileExists(filepath.Join(publicFolder, filename)) {
-			downloadItems = append(downloadItems, DownloadItem{
-				Type:    "core",
-				Version: core.Version,
-				URL:     core.Package,
-			})
+		item := DownloadItem{
+			Type:    "core",
+			Version: core.Version,
+			Locale:  core.Locale,
+			URL:     core.Package,
+		}
+		if dc.isMissing(item) {
+			downloadItems = append(downloadItems, item)
 		}
 	}
 
-	// Process plugin versions
+	// Process every retained, in-scope plugin version
 	for _, pluginFile := range pluginFiles {
-		latestVersion, err := GetLatestPluginVersion(pluginFile)
+		versions, err := dc.store.GetPluginVersions(pluginFile)
 		if err != nil {
-			fmt.Printf("Error getting latest version for plugin %s: %v\n", pluginFile, err)
+			fmt.Printf("Error getting versions for plugin %s: %v\n", pluginFile, err)
 			continue
 		}
 
-		filename := fmt.Sprintf("%s.%s.zip", pluginFile, latestVersion.NewVersion)
-		if !fileExists(filepath.Join(publicFolder, filename)) {
-			downloadItems = append(downloadItems, DownloadItem{
+		for _, version := range versions {
+			if !cfg.Scope.Plugins.AllowsVersion(pluginSlug(pluginFile), version.NewVersion) {
+				continue
+			}
+			item := DownloadItem{
 				Type:    "plugin",
-				Version: latestVersion.NewVersion,
-				URL:     latestVersion.Package,
-			})
+				Slug:    pluginFile,
+				Version: version.NewVersion,
+				URL:     version.Package,
+			}
+			if dc.isMissing(item) {
+				downloadItems = append(downloadItems, item)
+			}
 		}
 	}
 
-	// Process theme versions
+	// Process every retained, in-scope theme version
 	for _, themeSlug := range themeSlugs {
-		latestVersion, err := GetLatestThemeVersion(themeSlug)
+		versions, err := dc.store.GetThemeVersions(themeSlug)
 		if err != nil {
-			fmt.Printf("Error getting latest version for theme %s: %v\n", themeSlug, err)
+			fmt.Printf("Error getting versions for theme %s: %v\n", themeSlug, err)
 			continue
 		}
 
-		filename := fmt.Sprintf("%s.%s.zip", themeSlug, latestVersion.NewVersion)
-		if !fileExists(filepath.Join(publicFolder, filename)) {
-			downloadItems = append(downloadItems, DownloadItem{
+		for _, version := range versions {
+			if !cfg.Scope.Themes.AllowsVersion(themeSlug, version.NewVersion) {
+				continue
+			}
+			item := DownloadItem{
 				Type:    "theme",
-				Version: latestVersion.NewVersion,
-				URL:     latestVersion.Package,
-			})
+				Slug:    themeSlug,
+				Version: version.NewVersion,
+				URL:     version.Package,
+			}
+			if dc.isMissing(item) {
+				downloadItems = append(downloadItems, item)
+			}
 		}
 	}
 
-	// Store download items in Redis queue
+	pending, err := dc.pendingDownloads()
+	if err != nil {
+		return fmt.Errorf("error listing download states: %w", err)
+	}
+
+	// Store download items in the download queue, unless they are queued
+	// already or were abandoned recently
+	queued := 0
 	for _, item := range downloadItems {
-		jsonData, err := json.Marshal(item)
-		if err != nil {
-			fmt.Printf("Error marshaling download item: %v\n", err)
+		key := downloadKey(item)
+		if pending[key] {
 			continue
 		}
-
-		err = rdb.RPush(ctx, downloadQueue, jsonData).Err()
+		err := dc.store.PushDownload(item)
 		if err != nil {
 			fmt.Printf("Error adding item to download queue: %v\n", err)
+			continue
+		}
+		err = dc.store.SetDownloadState(DownloadState{Key: key, UpdatedAt: time.Now().UTC()})
+		if err != nil {
+			fmt.Printf("Error recording queued item %s: %v\n", key, err)
 		}
+		queued++
 	}
 
-	fmt.Printf("Added %d items to download queue\n", len(downloadItems))
+	fmt.Printf("Added %d items to download queue, skipped %d queued or abandoned\n", queued, len(downloadItems)-queued)
 	return nil
 }
 
-func fileExists(filename string) bool {
-	_, err := os.Stat(filename)
-	return !os.IsNotExist(err)
-}
-
-func main() {
-	// Initialize Redis connection
-	err := InitRedis("localhost:6379")
+// pendingDownloads returns the keys of the items queued or abandoned within
+// downloadStateMaxAge and removes the older states
+func (dc *DownloadChecker) pendingDownloads() (map[string]bool, error) {
+	states, err := dc.store.ListDownloadStates()
 	if err != nil {
-		fmt.Printf("Error initializing Redis: %v\n", err)
-		return
+		return nil, err
 	}
+	cutoff := time.Now().Add(-downloadStateMaxAge)
+	pending := make(map[string]bool, len(states))
+	for _, state := range states {
+		if state.UpdatedAt.After(cutoff) {
+			pending[state.Key] = true
+			continue
+		}
+		if err := dc.store.DeleteDownloadState(state.Key); err != nil {
+			fmt.Printf("Error removing download state %s: %v\n", state.Key, err)
+		}
+	}
+	return pending, nil
+}
 
-	// Start the background job
-	go BackgroundDownloadChecker()
-
-	// Keep the main goroutine running
-	select {}
+// isMissing reports whether the artifact of an item is not stored yet.
+// Items whose artifact cannot be checked are not queued.
+func (dc *DownloadChecker) isMissing(item DownloadItem) bool {
+	_, err := dc.artifacts.Stat(newArtifact(item))
+	if err != nil && !errors.Is(err, ErrArtifactNotFound) {
+		fmt.Printf("Error checking %s %s %s: %v\n", item.Type, item.Slug, item.Version, err)
+		return false
+	}
+	return err != nil
 }
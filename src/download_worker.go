@@ -1,58 +1,88 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"sync"
 	"time"
-
-	"github.com/go-redis/redis/v8"
 )
 
-const (
-	publicFolder  = "./public"
-	downloadQueue = "download_queue"
-	maxWorkers    = 5
-)
+// DownloadWorkers download queued items and record them in the store
+type DownloadWorkers struct {
+	store     MetadataStore
+	artifacts ArtifactStore
+}
 
-func DownloadWorker(id int, wg *sync.WaitGroup) {
+func NewDownloadWorkers(store MetadataStore, artifacts ArtifactStore) *DownloadWorkers {
+	return &DownloadWorkers{store: store, artifacts: artifacts}
+}
+
+// popRetryDelay is how long a worker waits after the queue failed to pop,
+// e.g. while Redis is unreachable, instead of retrying in a busy loop
+const popRetryDelay = 5 * time.Second
+
+func (dw *DownloadWorkers) DownloadWorker(id int, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for {
 		// Pop an item from the download queue
-		result, err := rdb.BLPop(ctx, 0, downloadQueue).Result()
+		item, err := dw.store.PopDownload()
 		if err != nil {
 			fmt.Printf("Worker %d: Error popping from queue: %v\n", id, err)
+			time.Sleep(popRetryDelay)
 			continue
 		}
+		dw.process(id, item)
+	}
+}
 
-		var item DownloadItem
-		err = json.Unmarshal([]byte(result[1]), &item)
-		if err != nil {
-			fmt.Printf("Worker %d: Error unmarshaling download item: %v\n", id, err)
-			continue
+// process downloads an item. Its version record was stored by whatever
+// queued it, so it is left as is. Items that fail verification, arrive
+// incomplete or whose checksums cannot be fetched are queued again until
+// they have been tried cfg.Worker.MaxAttempts times. Items given up on are
+// recorded as abandoned so the checker does not queue them right away.
+func (dw *DownloadWorkers) process(id int, item DownloadItem) {
+	// Download the file
+	err := dw.downloadFile(item)
+	if retryable(err) {
+		fmt.Printf("Worker %d: Error verifying %s %s: %v\n", id, item.Type, item.Version, err)
+		item.Attempts++
+		if item.Attempts >= cfg.Worker.MaxAttempts {
+			fmt.Printf("Worker %d: Giving up on %s %s after %d attempts\n", id, item.Type, item.Version, item.Attempts)
+			dw.abandon(id, item)
+			return
 		}
-
-		// Download the file
-		err = downloadFile(item)
-		if err != nil {
-			fmt.Printf("Worker %d: Error downloading file: %v\n", id, err)
-			continue
+		if err := dw.store.PushDownload(item); err != nil {
+			fmt.Printf("Worker %d: Error queueing retry: %v\n", id, err)
 		}
+		return
+	}
+	if err != nil {
+		fmt.Printf("Worker %d: Error downloading file: %v\n", id, err)
+		dw.abandon(id, item)
+		return
+	}
+	if err := dw.store.DeleteDownloadState(downloadKey(item)); err != nil {
+		fmt.Printf("Worker %d: Error clearing download state: %v\n", id, err)
+	}
+}
 
-		// Update Redis with the new file information
-		err = updateRedisInfo(item)
-		if err != nil {
-			fmt.Printf("Worker %d: Error updating Redis info: %v\n", id, err)
-		}
+// abandon records that the workers gave up on an item
+func (dw *DownloadWorkers) abandon(id int, item DownloadItem) {
+	state := DownloadState{Key: downloadKey(item), Abandoned: true, UpdatedAt: time.Now().UTC()}
+	if err := dw.store.SetDownloadState(state); err != nil {
+		fmt.Printf("Worker %d: Error recording abandoned item: %v\n", id, err)
 	}
 }
 
-func downloadFile(item DownloadItem) error {
+// retryable reports whether a failed download may succeed on another attempt
+func retryable(err error) bool {
+	return errors.Is(err, errChecksumMismatch) || errors.Is(err, errInvalidDownload) || errors.Is(err, errChecksumsUnavailable)
+}
+
+// downloadFile fetches the item and stores it as its artifact
+func (dw *DownloadWorkers) downloadFile(item DownloadItem) error {
 	fmt.Printf("Downloading %s version %s\n", item.Type, item.Version)
 
 	resp, err := http.Get(item.URL)
@@ -65,102 +95,37 @@ func downloadFile(item DownloadItem) error {
 		return fmt.Errorf("bad status: %s", resp.Status)
 	}
 
-	// Create the directory structure if it doesn't exist
-	dir := filepath.Join(publicFolder, item.Type)
-	err = os.MkdirAll(dir, 0755)
-	if err != nil {
-		return fmt.Errorf("error creating directory: %w", err)
+	if item.Type == "core" && cfg.Worker.VerifyCore {
+		if err := dw.downloadVerifiedCore(item, resp); err != nil {
+			return err
+		}
+		fmt.Printf("Downloaded and verified %s\n", item.URL)
+		return nil
 	}
 
-	// Create the file
-	filename := filepath.Join(dir, fmt.Sprintf("%s-%s.zip", item.Type, item.Version))
-	out, err := os.Create(filename)
+	// Stage and check the body before it is stored under the artifact's name
+	staged, err := stageDownload(resp.Body, nil)
 	if err != nil {
-		return fmt.Errorf("error creating file: %w", err)
+		return err
 	}
-	defer out.Close()
-
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return fmt.Errorf("error writing file: %w", err)
+	defer staged.discard()
+	if err := staged.validate(resp.ContentLength, nil); err != nil {
+		return err
 	}
-
-	fmt.Printf("Downloaded %s to %s\n", item.URL, filename)
-	return nil
-}
-
-func updateRedisInfo(item DownloadItem) error {
-	switch item.Type {
-	case "core":
-		// Update core version info
-		var coreVersion CoreVersion
-		coreVersion.Version = item.Version
-		coreVersion.Package = item.URL
-		jsonData, err := json.Marshal(coreVersion)
-		if err != nil {
-			return fmt.Errorf("error marshaling core version: %w", err)
-		}
-		err = rdb.HSet(ctx, "core_versions", item.Version, jsonData).Err()
-		if err != nil {
-			return fmt.Errorf("error updating core version in Redis: %w", err)
-		}
-	case "plugin":
-		// Update plugin version info
-		var pluginVersion PluginVersion
-		pluginVersion.NewVersion = item.Version
-		pluginVersion.Package = item.URL
-		jsonData, err := json.Marshal(pluginVersion)
-		if err != nil {
-			return fmt.Errorf("error marshaling plugin version: %w", err)
-		}
-		err = rdb.HSet(ctx, fmt.Sprintf("plugins:%s", item.Slug), item.Version, jsonData).Err()
-		if err != nil {
-			return fmt.Errorf("error updating plugin version in Redis: %w", err)
-		}
-	case "theme":
-		// Update theme version info
-		var themeVersion ThemeVersion
-		themeVersion.NewVersion = item.Version
-		themeVersion.Package = item.URL
-		jsonData, err := json.Marshal(themeVersion)
-		if err != nil {
-			return fmt.Errorf("error marshaling theme version: %w", err)
-		}
-		err = rdb.HSet(ctx, fmt.Sprintf("themes:%s", item.Slug), item.Version, jsonData).Err()
-		if err != nil {
-			return fmt.Errorf("error updating theme version in Redis: %w", err)
-		}
-	default:
-		return fmt.Errorf("unknown item type: %s", item.Type)
+	artifact := newArtifact(item)
+	if err := staged.publish(dw.artifacts, artifact); err != nil {
+		return err
 	}
 
+	fmt.Printf("Downloaded %s to %s\n", item.URL, artifact)
 	return nil
 }
 
-func StartDownloadWorkers() {
+func (dw *DownloadWorkers) Start() {
 	var wg sync.WaitGroup
-	for i := 0; i < maxWorkers; i++ {
+	for i := 0; i < cfg.Worker.MaxWorkers; i++ {
 		wg.Add(1)
-		go DownloadWorker(i, &wg)
+		go dw.DownloadWorker(i, &wg)
 	}
 	wg.Wait()
 }
-
-func main() {
-	// Initialize Redis connection
-	err := InitRedis("localhost:6379")
-	if err != nil {
-		fmt.Printf("Error initializing Redis: %v\n", err)
-		return
-	}
-
-	// Start the download workers
-	go StartDownloadWorkers()
-
-	// Start the background checker
-	go BackgroundDownloadChecker()
-
-	// Keep the main goroutine running
-	select {}
-}
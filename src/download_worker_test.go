@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerKeepsStoredVersionRecords(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testZip(t, r.URL.Path))
+	}))
+	defer upstream.Close()
+	setupProxyConfig(t, "http://127.0.0.1:1")
+	cfg.Proxy.Enabled = false
+	cfg.Worker.VerifyCore = false
+
+	store := NewMemoryStore()
+	plugin := PluginVersion{
+		Slug:        "akismet",
+		NewVersion:  "5.3",
+		URL:         "https://wordpress.org/plugins/akismet/",
+		Package:     upstream.URL + "/plugin/akismet.5.3.zip",
+		Icons:       FlexMap{"1x": "https://ps.w.org/akismet/assets/icon-128x128.png"},
+		Requires:    "5.8",
+		Tested:      "6.4",
+		RequiresPHP: "5.6.20",
+		Released:    "2023-09-28",
+	}
+	require.NoError(t, store.SetPluginVersions("akismet/akismet.php", []PluginVersion{plugin}))
+	core := CoreVersion{Version: "6.4.2", PHPVersion: "7.0.0", MySQLVersion: "5.0", Package: upstream.URL + "/release/wordpress-6.4.2.zip"}
+	require.NoError(t, store.SetCoreVersions([]CoreVersion{core}))
+
+	workers := NewDownloadWorkers(store, NewFileArtifactStore(cfg.Paths))
+	workers.process(0, DownloadItem{Type: "plugin", Slug: "akismet/akismet.php", Version: "5.3", URL: plugin.Package})
+	workers.process(0, DownloadItem{Type: "core", Version: "6.4.2", URL: core.Package})
+
+	versions, err := store.GetPluginVersions("akismet/akismet.php")
+	require.NoError(t, err)
+	assert.Equal(t, []PluginVersion{plugin}, versions)
+	coreVersions, err := store.GetCoreVersions()
+	require.NoError(t, err)
+	assert.Equal(t, []CoreVersion{core}, coreVersions)
+
+	// Sites are still offered the full record
+	form := url.Values{
+		"plugins": {`{"plugins":{"akismet/akismet.php":{"Name":"Akismet","Version":"5.2"}},"active":[]}`},
+		"locale":  {`["en_US"]`},
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/plugins/update-check/1.1/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	NewServer(store, NewFileArtifactStore(cfg.Paths)).setupRouter().ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	var response PluginUpdateCheckResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	update := response.Plugins["akismet/akismet.php"]
+	assert.Equal(t, "5.3", update.NewVersion)
+	assert.Equal(t, plugin.URL, update.URL)
+	assert.Equal(t, map[string]string(plugin.Icons), update.Icons)
+	assert.Equal(t, string(plugin.Requires), update.Requires)
+	assert.Equal(t, string(plugin.Tested), update.Tested)
+	assert.Equal(t, string(plugin.RequiresPHP), update.RequiresPHP)
+}
+
+func TestCheckerSkipsQueuedAndAbandonedItems(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/plugin/gone.1.0.zip" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(testZip(t, r.URL.Path))
+	}))
+	defer upstream.Close()
+	setupProxyConfig(t, "http://127.0.0.1:1")
+	cfg.Proxy.Enabled = false
+
+	store := NewMemoryStore()
+	for _, slug := range []string{"akismet", "gone"} {
+		require.NoError(t, store.SetPluginVersions(slug+"/"+slug+".php", []PluginVersion{
+			{Slug: slug, NewVersion: "1.0", Package: upstream.URL + "/plugin/" + slug + ".1.0.zip"},
+		}))
+	}
+	artifacts := NewFileArtifactStore(cfg.Paths)
+	checker := NewDownloadChecker(store, artifacts)
+	workers := NewDownloadWorkers(store, artifacts)
+
+	// Items still waiting in the queue are not queued twice
+	require.NoError(t, checker.checkAndQueueDownloads())
+	require.Len(t, store.queue, 2)
+	require.NoError(t, checker.checkAndQueueDownloads())
+	require.Len(t, store.queue, 2)
+
+	for len(store.queue) > 0 {
+		item, err := store.PopDownload()
+		require.NoError(t, err)
+		workers.process(0, item)
+	}
+
+	// The item the workers gave up on is left alone until its state expires
+	states, err := store.ListDownloadStates()
+	require.NoError(t, err)
+	require.Len(t, states, 1)
+	assert.Equal(t, "plugin/gone.1.0.zip", states[0].Key)
+	assert.True(t, states[0].Abandoned)
+	require.NoError(t, checker.checkAndQueueDownloads())
+	assert.Empty(t, store.queue)
+
+	states[0].UpdatedAt = time.Now().Add(-downloadStateMaxAge)
+	require.NoError(t, store.SetDownloadState(states[0]))
+	require.NoError(t, checker.checkAndQueueDownloads())
+	require.Len(t, store.queue, 1)
+	assert.Equal(t, "gone/gone.php", store.queue[0].Slug)
+}
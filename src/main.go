@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+const usage = `Usage: wp-mirror <command> [flags]
+
+Commands:
+  serve         Run the HTTP API and download server
+  check         Run the background download checker
+  work          Run the download workers
+  update        Run the periodic WordPress.org updater
+  discover      Fetch the plugins and themes clients asked for but are not mirrored
+  crawl         Crawl the WordPress.org plugin and theme directories once and exit
+  seed          Populate the store with dummy data and exit
+  all           Run serve, check, work, update and discover in one process
+  migrate       Apply pending schema migrations to the bolt store and exit
+  config print  Print the resolved configuration and exit
+
+Settings are read from the file given by -config (or WPMIRROR_CONFIG),
+then WPMIRROR_* environment variables, then flags.
+Run "wp-mirror <command> -h" to list the flags.
+`
+
+// commands maps each subcommand to the function that runs it
+var commands = map[string]func(store MetadataStore, artifacts ArtifactStore) error{
+	"serve":    cmdServe,
+	"check":    cmdCheck,
+	"work":     cmdWork,
+	"update":   cmdUpdate,
+	"crawl":    cmdCrawl,
+	"discover": cmdDiscover,
+	"seed":     cmdSeed,
+	"all":      cmdAll,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	name, args := os.Args[1], os.Args[2:]
+	if name == "help" || name == "-h" || name == "--help" {
+		fmt.Print(usage)
+		return
+	}
+
+	if name == "config" {
+		if len(args) == 0 || args[0] != "print" {
+			fmt.Fprintf(os.Stderr, "Unknown config command\n\n%s", usage)
+			os.Exit(2)
+		}
+		if err := loadGlobalConfig("config print", args[1:]); err != nil {
+			exitConfigError(err)
+		}
+		if err := cfg.Print(); err != nil {
+			log.Fatalf("Failed to print configuration: %v", err)
+		}
+		return
+	}
+
+	if name == "migrate" {
+		if err := loadGlobalConfig(name, args); err != nil {
+			exitConfigError(err)
+		}
+		if err := migrate(); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+
+	if err := loadGlobalConfig(name, args); err != nil {
+		exitConfigError(err)
+	}
+
+	store, err := bootstrap()
+	if err != nil {
+		log.Fatalf("Failed to open %s store: %v", cfg.Storage.Backend, err)
+	}
+
+	artifacts, err := openArtifactStore(store)
+	if err != nil {
+		log.Fatalf("Failed to open %s artifact store: %v", cfg.Artifacts.Backend, err)
+	}
+
+	// Downloads interrupted by a crash leave partial files behind
+	if removed, err := cleanPartials(artifacts); err != nil {
+		log.Printf("Error removing partial downloads: %v", err)
+	} else if removed > 0 {
+		log.Printf("Removed %d partial downloads", removed)
+	}
+
+	if err := cmd(store, artifacts); err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+}
+
+// loadGlobalConfig resolves the configuration for a command and installs it
+func loadGlobalConfig(name string, args []string) error {
+	c, err := loadConfig(name, args)
+	if err != nil {
+		return err
+	}
+	cfg = c
+	return nil
+}
+
+func exitConfigError(err error) {
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+	os.Exit(2)
+}
+
+// bootstrap performs the setup shared by every subcommand and returns the
+// metadata store handed to the components
+func bootstrap() (MetadataStore, error) {
+	switch cfg.Storage.Backend {
+	case "memory":
+		log.Println("Using in-memory store; data is lost when the process exits")
+		return NewMemoryStore(), nil
+	case "bolt":
+		return OpenBoltStore(cfg.Bolt.Path)
+	default:
+		return InitRedis(cfg.Redis.Addr)
+	}
+}
+
+// openArtifactStore returns the store of the release zips handed to the
+// components. With content addressing, store keeps the digest mappings.
+func openArtifactStore(store MetadataStore) (ArtifactStore, error) {
+	var artifacts ArtifactStore = NewFileArtifactStore(cfg.Paths)
+	if cfg.Artifacts.Backend == "s3" {
+		s3, err := NewS3ArtifactStore(cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = s3
+	}
+	if cfg.Artifacts.ContentAddressed {
+		return NewContentStore(artifacts, store), nil
+	}
+	return artifacts, nil
+}
+
+// migrate brings the configured store's schema up to date
+func migrate() error {
+	if cfg.Storage.Backend != "bolt" {
+		log.Printf("The %s store has no schema; nothing to migrate", cfg.Storage.Backend)
+		return nil
+	}
+
+	from, to, err := MigrateBolt(cfg.Bolt.Path)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		log.Printf("Schema of %s is up to date at version %d", cfg.Bolt.Path, to)
+	} else {
+		log.Printf("Migrated %s from schema version %d to %d", cfg.Bolt.Path, from, to)
+	}
+	return nil
+}
+
+func cmdServe(store MetadataStore, artifacts ArtifactStore) error {
+	return NewServer(store, artifacts).runServer(cfg.Server.Listen)
+}
+
+func cmdCheck(store MetadataStore, artifacts ArtifactStore) error {
+	NewDownloadChecker(store, artifacts).Run()
+	return nil
+}
+
+func cmdWork(store MetadataStore, artifacts ArtifactStore) error {
+	NewDownloadWorkers(store, artifacts).Start()
+	return nil
+}
+
+func cmdUpdate(store MetadataStore, artifacts ArtifactStore) error {
+	NewUpdater(store).runWPUpdater()
+	return nil
+}
+
+// cmdCrawl runs one crawl in the configured mode. It holds the updater lock
+// so it does not race the updater for the crawl cursor.
+func cmdCrawl(store MetadataStore, artifacts ArtifactStore) error {
+	u := NewUpdater(store)
+	if !u.acquireLock() {
+		return errors.New("the updater lock is held by another instance")
+	}
+	defer u.releaseLock()
+
+	crawler := NewCrawler(store)
+	for _, dir := range []crawlDirectory{pluginDirectory, themeDirectory} {
+		if err := crawler.Crawl(dir, cfg.Crawler.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdDiscover(store MetadataStore, artifacts ArtifactStore) error {
+	NewDemandFetcher(store).Run()
+	return nil
+}
+
+func cmdSeed(store MetadataStore, artifacts ArtifactStore) error {
+	seedDummyData(store)
+	return nil
+}
+
+// cmdAll runs every long-running component in this process. The background
+// jobs run in their own goroutines and the HTTP server keeps the process alive.
+func cmdAll(store MetadataStore, artifacts ArtifactStore) error {
+	go NewUpdater(store).runWPUpdater()
+	go NewDownloadChecker(store, artifacts).Run()
+	go NewDownloadWorkers(store, artifacts).Start()
+	go NewDemandFetcher(store).Run()
+	return NewServer(store, artifacts).runServer(cfg.Server.Listen)
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a MetadataStore kept entirely in process memory. It mirrors
+// the Redis layout: core versions keyed by version, one version map per
+// plugin file and theme slug, and a FIFO download queue with blocking pop.
+type MemoryStore struct {
+	mu       sync.Mutex
+	nonEmpty *sync.Cond
+
+	coreVersions map[string]CoreVersion
+	plugins      map[string]map[string]PluginVersion
+	pluginInfos  map[string]PluginInfo
+	themeInfos   map[string]ThemeInfo
+	cursors      map[string]CrawlCursor
+	demands      map[string]map[string]Demand
+	rollbacks    map[string]Rollback
+	digests      map[string]string
+	blobRefs     map[string]map[string]bool
+	themes       map[string]map[string]ThemeVersion
+	queue        []DownloadItem
+	downloads    map[string]DownloadState
+	locks        map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		coreVersions: make(map[string]CoreVersion),
+		plugins:      make(map[string]map[string]PluginVersion),
+		pluginInfos:  make(map[string]PluginInfo),
+		themeInfos:   make(map[string]ThemeInfo),
+		cursors:      make(map[string]CrawlCursor),
+		demands:      make(map[string]map[string]Demand),
+		rollbacks:    make(map[string]Rollback),
+		digests:      make(map[string]string),
+		blobRefs:     make(map[string]map[string]bool),
+		themes:       make(map[string]map[string]ThemeVersion),
+		downloads:    make(map[string]DownloadState),
+		locks:        make(map[string]time.Time),
+	}
+	s.nonEmpty = sync.NewCond(&s.mu)
+	return s
+}
+
+// SetCoreVersions sets the list of core version information
+func (s *MemoryStore) SetCoreVersions(versions []CoreVersion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range versions {
+		s.coreVersions[v.key()] = v
+	}
+	return nil
+}
+
+// GetCoreVersions gets the list of core version information
+func (s *MemoryStore) GetCoreVersions() ([]CoreVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := make([]CoreVersion, 0, len(s.coreVersions))
+	for _, v := range s.coreVersions {
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// SetPluginVersions sets the list of plugin version information for a given plugin file
+func (s *MemoryStore) SetPluginVersions(pluginFile string, versions []PluginVersion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.plugins[pluginFile] == nil {
+		s.plugins[pluginFile] = make(map[string]PluginVersion)
+	}
+	for _, v := range versions {
+		s.plugins[pluginFile][v.NewVersion] = v
+	}
+	return nil
+}
+
+// GetPluginVersions gets the list of plugin version information for a given plugin file
+func (s *MemoryStore) GetPluginVersions(pluginFile string) ([]PluginVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := make([]PluginVersion, 0, len(s.plugins[pluginFile]))
+	for _, v := range s.plugins[pluginFile] {
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// DeletePluginVersions removes versions of a plugin file, dropping the file
+// once it has none left
+func (s *MemoryStore) DeletePluginVersions(pluginFile string, versions []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range versions {
+		delete(s.plugins[pluginFile], v)
+	}
+	if len(s.plugins[pluginFile]) == 0 {
+		delete(s.plugins, pluginFile)
+	}
+	return nil
+}
+
+// GetLatestPluginVersion gets the latest plugin version information for a given plugin file
+func (s *MemoryStore) GetLatestPluginVersion(pluginFile string) (*PluginVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := s.plugins[pluginFile]
+	if len(versions) == 0 {
+		return nil, ErrNoPluginVersions
+	}
+
+	var latestVersion PluginVersion
+	var latestVersionNumber string
+	for version, v := range versions {
+		if CompareVersions(version, latestVersionNumber) > 0 {
+			latestVersionNumber = version
+			latestVersion = v
+		}
+	}
+	return &latestVersion, nil
+}
+
+// ListAllPluginFiles lists all stored plugin files
+func (s *MemoryStore) ListAllPluginFiles() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pluginFiles := make([]string, 0, len(s.plugins))
+	for pluginFile := range s.plugins {
+		pluginFiles = append(pluginFiles, pluginFile)
+	}
+	sort.Strings(pluginFiles)
+	return pluginFiles, nil
+}
+
+// SetPluginInfo stores the full plugin record for its slug
+func (s *MemoryStore) SetPluginInfo(info PluginInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pluginInfos[info.Slug] = info
+	return nil
+}
+
+// GetPluginInfo gets the full plugin record for a given slug
+func (s *MemoryStore) GetPluginInfo(slug string) (*PluginInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.pluginInfos[slug]
+	if !ok {
+		return nil, ErrPluginInfoNotFound
+	}
+	return &info, nil
+}
+
+// ListPluginSummaries lists the summaries of all stored plugin records
+func (s *MemoryStore) ListPluginSummaries() ([]PluginInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summaries := make([]PluginInfo, 0, len(s.pluginInfos))
+	for _, info := range s.pluginInfos {
+		summaries = append(summaries, info.Summary())
+	}
+	return summaries, nil
+}
+
+// SetThemeInfo stores the full theme record for its slug
+func (s *MemoryStore) SetThemeInfo(info ThemeInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.themeInfos[info.Slug] = info
+	return nil
+}
+
+// GetThemeInfo gets the full theme record for a given slug
+func (s *MemoryStore) GetThemeInfo(slug string) (*ThemeInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.themeInfos[slug]
+	if !ok {
+		return nil, ErrThemeInfoNotFound
+	}
+	return &info, nil
+}
+
+// ListThemeSummaries lists the summaries of all stored theme records
+func (s *MemoryStore) ListThemeSummaries() ([]ThemeInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summaries := make([]ThemeInfo, 0, len(s.themeInfos))
+	for _, info := range s.themeInfos {
+		summaries = append(summaries, info.Summary())
+	}
+	return summaries, nil
+}
+
+// SetThemeVersions sets the list of theme version information for a given theme slug
+func (s *MemoryStore) SetThemeVersions(themeSlug string, versions []ThemeVersion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.themes[themeSlug] == nil {
+		s.themes[themeSlug] = make(map[string]ThemeVersion)
+	}
+	for _, v := range versions {
+		s.themes[themeSlug][v.NewVersion] = v
+	}
+	return nil
+}
+
+// GetThemeVersions gets the list of theme version information for a given theme slug
+func (s *MemoryStore) GetThemeVersions(themeSlug string) ([]ThemeVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := make([]ThemeVersion, 0, len(s.themes[themeSlug]))
+	for _, v := range s.themes[themeSlug] {
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// DeleteThemeVersions removes versions of a theme slug, dropping the slug
+// once it has none left
+func (s *MemoryStore) DeleteThemeVersions(themeSlug string, versions []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range versions {
+		delete(s.themes[themeSlug], v)
+	}
+	if len(s.themes[themeSlug]) == 0 {
+		delete(s.themes, themeSlug)
+	}
+	return nil
+}
+
+// GetLatestThemeVersion gets the latest theme version information for a given theme slug
+func (s *MemoryStore) GetLatestThemeVersion(themeSlug string) (*ThemeVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := s.themes[themeSlug]
+	if len(versions) == 0 {
+		return nil, ErrNoThemeVersions
+	}
+
+	var latestVersion ThemeVersion
+	var latestVersionNumber string
+	for version, v := range versions {
+		if CompareVersions(version, latestVersionNumber) > 0 {
+			latestVersionNumber = version
+			latestVersion = v
+		}
+	}
+	return &latestVersion, nil
+}
+
+// ListAllThemeSlugs lists all stored theme slugs
+func (s *MemoryStore) ListAllThemeSlugs() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	themeSlugs := make([]string, 0, len(s.themes))
+	for themeSlug := range s.themes {
+		themeSlugs = append(themeSlugs, themeSlug)
+	}
+	sort.Strings(themeSlugs)
+	return themeSlugs, nil
+}
+
+// PushDownload appends an item to the download queue and wakes a waiting worker
+func (s *MemoryStore) PushDownload(item DownloadItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queue = append(s.queue, item)
+	s.nonEmpty.Signal()
+	return nil
+}
+
+// PopDownload blocks until an item is available in the download queue
+func (s *MemoryStore) PopDownload() (DownloadItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.queue) == 0 {
+		s.nonEmpty.Wait()
+	}
+	item := s.queue[0]
+	s.queue = s.queue[1:]
+	return item, nil
+}
+
+// SetDownloadState stores the state of an item
+func (s *MemoryStore) SetDownloadState(state DownloadState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.downloads[state.Key] = state
+	return nil
+}
+
+// ListDownloadStates lists the download states, ordered by key
+func (s *MemoryStore) ListDownloadStates() ([]DownloadState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make([]DownloadState, 0, len(s.downloads))
+	for _, state := range s.downloads {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Key < states[j].Key })
+	return states, nil
+}
+
+// DeleteDownloadState removes the state of an item
+func (s *MemoryStore) DeleteDownloadState(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.downloads, key)
+	return nil
+}
+
+// GetCrawlCursor gets the named crawl cursor
+func (s *MemoryStore) GetCrawlCursor(name string) (*CrawlCursor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cursor := s.cursors[name]
+	return &cursor, nil
+}
+
+// SetCrawlCursor stores the named crawl cursor
+func (s *MemoryStore) SetCrawlCursor(name string, cursor CrawlCursor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cursors[name] = cursor
+	return nil
+}
+
+// RecordDemand records a demanded slug unless it already is
+func (s *MemoryStore) RecordDemand(kind, slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.demands[kind] == nil {
+		s.demands[kind] = make(map[string]Demand)
+	}
+	if _, ok := s.demands[kind][slug]; !ok {
+		s.demands[kind][slug] = Demand{Slug: slug, RequestedAt: time.Now().UTC()}
+	}
+	return nil
+}
+
+// ListDemands lists the demanded slugs of kind
+func (s *MemoryStore) ListDemands(kind string) ([]Demand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	demands := make([]Demand, 0, len(s.demands[kind]))
+	for _, d := range s.demands[kind] {
+		demands = append(demands, d)
+	}
+	sort.Slice(demands, func(i, j int) bool { return demands[i].Slug < demands[j].Slug })
+	return demands, nil
+}
+
+// SetDemand stores a demand
+func (s *MemoryStore) SetDemand(kind string, demand Demand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.demands[kind] == nil {
+		s.demands[kind] = make(map[string]Demand)
+	}
+	s.demands[kind][demand.Slug] = demand
+	return nil
+}
+
+// DeleteDemand removes a demanded slug
+func (s *MemoryStore) DeleteDemand(kind, slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.demands[kind], slug)
+	return nil
+}
+
+// SetRollback stores a rollback
+func (s *MemoryStore) SetRollback(rollback Rollback) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rollbacks[rollback.key()] = rollback
+	return nil
+}
+
+// GetRollback gets the rollback of a slug of kind
+func (s *MemoryStore) GetRollback(kind, slug string) (*Rollback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rollback, ok := s.rollbacks[rollbackKey(kind, slug)]
+	if !ok {
+		return nil, ErrRollbackNotFound
+	}
+	return &rollback, nil
+}
+
+// ListRollbacks lists every rollback
+func (s *MemoryStore) ListRollbacks() ([]Rollback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rollbacks := make([]Rollback, 0, len(s.rollbacks))
+	for _, r := range s.rollbacks {
+		rollbacks = append(rollbacks, r)
+	}
+	sort.Slice(rollbacks, func(i, j int) bool { return rollbacks[i].key() < rollbacks[j].key() })
+	return rollbacks, nil
+}
+
+// DeleteRollback removes the rollback of a slug of kind
+func (s *MemoryStore) DeleteRollback(kind, slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.rollbacks, rollbackKey(kind, slug))
+	return nil
+}
+
+// SetArtifactDigest maps an artifact key to a digest and records the key as
+// a reference of the digest
+func (s *MemoryStore) SetArtifactDigest(key, digest string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.digests[key]
+	s.digests[key] = digest
+	if s.blobRefs[digest] == nil {
+		s.blobRefs[digest] = make(map[string]bool)
+	}
+	s.blobRefs[digest][key] = true
+	if old == "" || old == digest {
+		return "", nil
+	}
+	return s.unreference(old, key), nil
+}
+
+// GetArtifactDigest gets the digest of an artifact key
+func (s *MemoryStore) GetArtifactDigest(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digest, ok := s.digests[key]
+	if !ok {
+		return "", ErrArtifactNotFound
+	}
+	return digest, nil
+}
+
+// ListArtifactDigests lists the digests of the keys starting with prefix
+func (s *MemoryStore) ListArtifactDigests(prefix string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digests := make(map[string]string)
+	for key, digest := range s.digests {
+		if strings.HasPrefix(key, prefix) {
+			digests[key] = digest
+		}
+	}
+	return digests, nil
+}
+
+// DeleteArtifactDigest removes the mapping of an artifact key
+func (s *MemoryStore) DeleteArtifactDigest(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digest, ok := s.digests[key]
+	if !ok {
+		return "", nil
+	}
+	delete(s.digests, key)
+	return s.unreference(digest, key), nil
+}
+
+// unreference drops key from the references of digest and returns digest
+// if that was the last one
+func (s *MemoryStore) unreference(digest, key string) string {
+	delete(s.blobRefs[digest], key)
+	if len(s.blobRefs[digest]) > 0 {
+		return ""
+	}
+	delete(s.blobRefs, digest)
+	return digest
+}
+
+// AcquireLock takes the lock unless another holder's lock has not expired
+func (s *MemoryStore) AcquireLock(key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if expiry, ok := s.locks[key]; ok && now.Before(expiry) {
+		return false, nil
+	}
+	s.locks[key] = now.Add(ttl)
+	return true, nil
+}
+
+// ReleaseLock frees the lock
+func (s *MemoryStore) ReleaseLock(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.locks, key)
+	return nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultQueryPerPage = 24
+	maxQueryPerPage     = 250
+)
+
+// PluginInfo is the full plugin record of the plugins/info/1.2/ API as
+// returned by plugin_information. Fields the mirror does not interpret are
+// kept as raw JSON so they are served back unchanged.
+type PluginInfo struct {
+	Name                   string          `json:"name"`
+	Slug                   string          `json:"slug"`
+	Version                string          `json:"version"`
+	Author                 string          `json:"author"`
+	AuthorProfile          string          `json:"author_profile"`
+	Contributors           json.RawMessage `json:"contributors,omitempty"`
+	Requires               FlexString      `json:"requires"`
+	Tested                 FlexString      `json:"tested"`
+	RequiresPHP            FlexString      `json:"requires_php"`
+	RequiresPlugins        json.RawMessage `json:"requires_plugins,omitempty"`
+	Rating                 float64         `json:"rating"`
+	Ratings                json.RawMessage `json:"ratings,omitempty"`
+	NumRatings             int             `json:"num_ratings"`
+	SupportThreads         int             `json:"support_threads"`
+	SupportThreadsResolved int             `json:"support_threads_resolved"`
+	ActiveInstalls         int             `json:"active_installs"`
+	Downloaded             int             `json:"downloaded"`
+	LastUpdated            string          `json:"last_updated"`
+	Added                  string          `json:"added"`
+	Homepage               FlexString      `json:"homepage"`
+	ShortDescription       string          `json:"short_description,omitempty"`
+	Description            string          `json:"description,omitempty"`
+	Sections               FlexMap         `json:"sections,omitempty"`
+	DownloadLink           string          `json:"download_link"`
+	Screenshots            json.RawMessage `json:"screenshots,omitempty"`
+	Tags                   FlexMap         `json:"tags"`
+	Versions               FlexMap         `json:"versions,omitempty"`
+	DonateLink             FlexString      `json:"donate_link,omitempty"`
+	Icons                  FlexMap         `json:"icons,omitempty"`
+	Banners                FlexMap         `json:"banners,omitempty"`
+}
+
+// Summary returns the record without the bulky fields query_plugins leaves
+// out of its results. The contributors are kept for the author filter and
+// dropped from the results by handleQueryPlugins.
+func (info PluginInfo) Summary() PluginInfo {
+	info.Description = ""
+	info.Sections = nil
+	info.Screenshots = nil
+	info.Versions = nil
+	return info
+}
+
+// PluginVersion returns the update information of the record's current
+// version
+func (info PluginInfo) PluginVersion() PluginVersion {
+	return PluginVersion{
+		Slug:        info.Slug,
+		NewVersion:  info.Version,
+		URL:         "https://wordpress.org/plugins/" + info.Slug + "/",
+		Package:     info.DownloadLink,
+		Icons:       info.Icons,
+		Banners:     info.Banners,
+		Requires:    info.Requires,
+		Tested:      info.Tested,
+		RequiresPHP: info.RequiresPHP,
+		Released:    releaseDate(info.LastUpdated),
+	}
+}
+
+// QueryInfo is the "info" object of query_plugins and query_themes
+type QueryInfo struct {
+	Page    int `json:"page"`
+	Pages   int `json:"pages"`
+	Results int `json:"results"`
+}
+
+// UnmarshalJSON accepts the counts as numbers or strings, as the themes API
+// sends some of them quoted
+func (i *QueryInfo) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Page    FlexString `json:"page"`
+		Pages   FlexString `json:"pages"`
+		Results FlexString `json:"results"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	i.Page, _ = strconv.Atoi(string(raw.Page))
+	i.Pages, _ = strconv.Atoi(string(raw.Pages))
+	i.Results, _ = strconv.Atoi(string(raw.Results))
+	return nil
+}
+
+// directoryQuery holds the filters of a query_plugins or query_themes call
+type directoryQuery struct {
+	Search  string
+	Tags    []string
+	Author  string
+	Browse  string
+	Page    int
+	PerPage int
+}
+
+// requestParam reads request[name] from the query string or the form body
+func requestParam(c *gin.Context, name string) string {
+	key := "request[" + name + "]"
+	if v, ok := c.GetQuery(key); ok {
+		return v
+	}
+	return c.PostForm(key)
+}
+
+// requestParamList reads request[name] or request[name][] as a list
+func requestParamList(c *gin.Context, name string) []string {
+	var values []string
+	for _, key := range []string{"request[" + name + "]", "request[" + name + "][]"} {
+		values = append(values, c.QueryArray(key)...)
+		values = append(values, c.PostFormArray(key)...)
+	}
+	return values
+}
+
+// directoryQueryFromRequest parses the query_* request parameters
+func directoryQueryFromRequest(c *gin.Context) directoryQuery {
+	q := directoryQuery{
+		Search:  strings.TrimSpace(requestParam(c, "search")),
+		Tags:    requestParamList(c, "tag"),
+		Author:  strings.TrimSpace(requestParam(c, "author")),
+		Browse:  requestParam(c, "browse"),
+		Page:    1,
+		PerPage: defaultQueryPerPage,
+	}
+	if page, err := strconv.Atoi(requestParam(c, "page")); err == nil && page > 0 {
+		q.Page = page
+	}
+	if perPage, err := strconv.Atoi(requestParam(c, "per_page")); err == nil && perPage > 0 {
+		q.PerPage = perPage
+	}
+	if q.PerPage > maxQueryPerPage {
+		q.PerPage = maxQueryPerPage
+	}
+	return q
+}
+
+// paginate returns the bounds of the requested page and the query info
+func (q directoryQuery) paginate(total int) (start, end int, info QueryInfo) {
+	info = QueryInfo{
+		Page:    q.Page,
+		Pages:   (total + q.PerPage - 1) / q.PerPage,
+		Results: total,
+	}
+	// Pages past the last one are empty. Clamping keeps huge page numbers
+	// from overflowing the offset.
+	page := q.Page
+	if page > info.Pages+1 {
+		page = info.Pages + 1
+	}
+	start = (page - 1) * q.PerPage
+	if start > total {
+		start = total
+	}
+	end = start + q.PerPage
+	if end > total {
+		end = total
+	}
+	return start, end, info
+}
+
+// handlePluginsInfo serves api.wordpress.org's /plugins/info/1.2/ for the
+// plugin_information and query_plugins actions from the stored records
+func (s *Server) handlePluginsInfo(c *gin.Context) {
+	action := c.Query("action")
+	if action == "" {
+		action = c.PostForm("action")
+	}
+
+	switch action {
+	case "plugin_information":
+		s.handlePluginInformation(c)
+	case "query_plugins":
+		s.handleQueryPlugins(c)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Action not implemented. <a href=\"https://codex.wordpress.org/WordPress.org_API\">API Docs</a>"})
+	}
+}
+
+func (s *Server) handlePluginInformation(c *gin.Context) {
+	slug := requestParam(c, "slug")
+	info, err := s.store.GetPluginInfo(slug)
+	if err == nil && !cfg.Scope.Plugins.Allows(slug) {
+		err = ErrPluginInfoNotFound
+	}
+	if err != nil {
+		if !errors.Is(err, ErrPluginInfoNotFound) {
+			log.Printf("Error retrieving plugin information for %s: %v", slug, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve plugin information"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Plugin not found."})
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+func (s *Server) handleQueryPlugins(c *gin.Context) {
+	plugins, err := s.store.ListPluginSummaries()
+	if err != nil {
+		log.Printf("Error listing plugins: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query plugins"})
+		return
+	}
+
+	q := directoryQueryFromRequest(c)
+	matched := filterPlugins(plugins, q)
+	start, end, info := q.paginate(len(matched))
+	page := matched[start:end]
+	for i := range page {
+		page[i].Contributors = nil
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"info":    info,
+		"plugins": page,
+	})
+}
+
+// filterPlugins applies the search, tag and author filters and orders the
+// result like the browse parameter asks, most active installs first by
+// default
+func filterPlugins(plugins []PluginInfo, q directoryQuery) []PluginInfo {
+	search := strings.ToLower(q.Search)
+	author := strings.ToLower(q.Author)
+
+	matched := []PluginInfo{}
+	for _, p := range plugins {
+		if !cfg.Scope.Plugins.Allows(p.Slug) {
+			continue
+		}
+		if search != "" && !containsFold(search, p.Name, p.Slug, p.ShortDescription, tagText(p.Tags)) {
+			continue
+		}
+		if len(q.Tags) > 0 && !hasAnyTag(p.Tags, q.Tags) {
+			continue
+		}
+		if author != "" && profileUsername(p.AuthorProfile) != author && !hasContributor(p.Contributors, author) {
+			continue
+		}
+		matched = append(matched, p)
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		switch q.Browse {
+		case "new":
+			return a.Added > b.Added
+		case "updated":
+			return parseWPOrgTime(a.LastUpdated).After(parseWPOrgTime(b.LastUpdated))
+		case "top-rated":
+			return a.Rating > b.Rating
+		default:
+			return a.ActiveInstalls > b.ActiveInstalls
+		}
+	})
+	return matched
+}
+
+// containsFold reports whether any of the fields contains the lower-cased
+// needle, ignoring case
+func containsFold(needle string, fields ...string) bool {
+	for _, f := range fields {
+		if strings.Contains(strings.ToLower(f), needle) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyTag(tags FlexMap, wanted []string) bool {
+	for _, w := range wanted {
+		if _, ok := tags[strings.ToLower(w)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tagText joins the slugs and names of tags for searching
+func tagText(tags FlexMap) string {
+	var b strings.Builder
+	for slug, name := range tags {
+		b.WriteString(slug + " " + name + " ")
+	}
+	return b.String()
+}
+
+// profileUsername extracts "automattic" from
+// "https://profiles.wordpress.org/automattic/"
+func profileUsername(profile string) string {
+	profile = strings.TrimSuffix(profile, "/")
+	return strings.ToLower(profile[strings.LastIndex(profile, "/")+1:])
+}
+
+// hasContributor reports whether username is a key of the contributors
+// object
+func hasContributor(contributors json.RawMessage, username string) bool {
+	if len(contributors) == 0 {
+		return false
+	}
+	var byName map[string]json.RawMessage
+	if err := json.Unmarshal(contributors, &byName); err != nil {
+		return false
+	}
+	for name := range byName {
+		if strings.ToLower(name) == username {
+			return true
+		}
+	}
+	return false
+}
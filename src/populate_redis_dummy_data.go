@@ -4,16 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-
-	"github.com/go-redis/redis/v8"
 )
 
-func main() {
-	// Initialize Redis connection
-	rdb := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-	})
-
+// seedDummyData populates the store with sample core, plugin and theme versions
+func seedDummyData(store MetadataStore) {
 	// Populate Core Versions
 	coreVersions := []CoreVersion{
 		{
@@ -39,8 +33,7 @@ func main() {
 	}
 
 	for _, v := range coreVersions {
-		jsonData, _ := json.Marshal(v)
-		err := rdb.HSet(ctx, "core_versions", v.Version, jsonData).Err()
+		err := store.SetCoreVersions([]CoreVersion{v})
 		if err != nil {
 			log.Printf("Error setting core version %s: %v", v.Version, err)
 		}
@@ -67,16 +60,65 @@ func main() {
 	}
 
 	for pluginFile, versions := range plugins {
-		key := fmt.Sprintf("plugins:%s", pluginFile)
 		for _, v := range versions {
-			jsonData, _ := json.Marshal(v)
-			err := rdb.HSet(ctx, key, v.NewVersion, jsonData).Err()
+			err := store.SetPluginVersions(pluginFile, []PluginVersion{v})
 			if err != nil {
 				log.Printf("Error setting plugin version %s for %s: %v", v.NewVersion, pluginFile, err)
 			}
 		}
 	}
 
+	// Populate Plugin Information
+	pluginInfos := []PluginInfo{
+		{
+			Name:             "Contact Form 7",
+			Slug:             "contact-form-7",
+			Version:          "5.7.2",
+			Author:           "Takayuki Miyoshi",
+			AuthorProfile:    "https://profiles.wordpress.org/takayukister/",
+			Contributors:     json.RawMessage(`{"takayukister":{"profile":"https://profiles.wordpress.org/takayukister/"}}`),
+			Requires:         "6.0",
+			Tested:           "6.2",
+			RequiresPHP:      "7.4",
+			Rating:           82,
+			ActiveInstalls:   5000000,
+			LastUpdated:      "2023-01-06 8:41am GMT",
+			Added:            "2007-08-02",
+			ShortDescription: "Just another contact form plugin. Simple but flexible.",
+			Sections:         FlexMap{"description": "<p>Contact Form 7 can manage multiple contact forms.</p>"},
+			DownloadLink:     "https://wp-mirror.blogvault.net/plugin/contact-form-7.5.7.2.zip",
+			Tags:             FlexMap{"contact": "contact", "contact-form": "contact form", "email": "email"},
+			Versions:         FlexMap{"5.7.2": "https://wp-mirror.blogvault.net/plugin/contact-form-7.5.7.2.zip"},
+		},
+		{
+			Name:             "Akismet Anti-Spam: Spam Protection",
+			Slug:             "akismet",
+			Version:          "5.1",
+			Author:           "Automattic",
+			AuthorProfile:    "https://profiles.wordpress.org/automattic/",
+			Contributors:     json.RawMessage(`{"automattic":{"profile":"https://profiles.wordpress.org/automattic/"}}`),
+			Requires:         "5.0",
+			Tested:           "6.2",
+			RequiresPHP:      "5.6.20",
+			Rating:           94,
+			ActiveInstalls:   5000000,
+			LastUpdated:      "2023-04-05 3:20pm GMT",
+			Added:            "2005-10-20",
+			ShortDescription: "The best anti-spam protection to block spam comments and spam in a contact form.",
+			Sections:         FlexMap{"description": "<p>Akismet checks your comments and contact form submissions.</p>"},
+			DownloadLink:     "https://wp-mirror.blogvault.net/plugin/akismet.5.1.zip",
+			Tags:             FlexMap{"anti-spam": "anti-spam", "comments": "comments", "spam": "spam"},
+			Versions:         FlexMap{"5.1": "https://wp-mirror.blogvault.net/plugin/akismet.5.1.zip"},
+		},
+	}
+
+	for _, info := range pluginInfos {
+		err := store.SetPluginInfo(info)
+		if err != nil {
+			log.Printf("Error setting plugin information for %s: %v", info.Slug, err)
+		}
+	}
+
 	// Populate Theme Versions
 	themes := map[string][]ThemeVersion{
 		"twentytwentythree": {
@@ -98,15 +140,58 @@ func main() {
 	}
 
 	for themeSlug, versions := range themes {
-		key := fmt.Sprintf("themes:%s", themeSlug)
 		for _, v := range versions {
-			jsonData, _ := json.Marshal(v)
-			err := rdb.HSet(ctx, key, v.NewVersion, jsonData).Err()
+			err := store.SetThemeVersions(themeSlug, []ThemeVersion{v})
 			if err != nil {
 				log.Printf("Error setting theme version %s for %s: %v", v.NewVersion, themeSlug, err)
 			}
 		}
 	}
 
+	// Populate Theme Information
+	themeInfos := []ThemeInfo{
+		{
+			Name:            "Twenty Twenty-Three",
+			Slug:            "twentytwentythree",
+			Version:         "1.1",
+			Author:          json.RawMessage(`"wordpressdotorg"`),
+			ActiveInstalls:  900000,
+			LastUpdated:     "2023-03-29",
+			LastUpdatedTime: "2023-03-29 18:48:52",
+			CreationTime:    "2022-11-01 12:40:50",
+			Description:     "Twenty Twenty-Three is designed to take advantage of the new design tools introduced in WordPress 6.1.",
+			Sections:        FlexMap{"description": "Twenty Twenty-Three is designed to take advantage of the new design tools introduced in WordPress 6.1."},
+			DownloadLink:    "https://wp-mirror.blogvault.net/theme/twentytwentythree.1.1.zip",
+			Tags:            FlexMap{"blog": "Blog", "block-patterns": "Block patterns", "full-site-editing": "Site editor", "one-column": "One column"},
+			Versions:        FlexMap{"1.1": "https://wp-mirror.blogvault.net/theme/twentytwentythree.1.1.zip"},
+			Requires:        "6.1",
+			RequiresPHP:     "5.6",
+		},
+		{
+			Name:            "Twenty Twenty-Two",
+			Slug:            "twentytwentytwo",
+			Version:         "1.4",
+			Author:          json.RawMessage(`"wordpressdotorg"`),
+			ActiveInstalls:  1000000,
+			LastUpdated:     "2023-03-29",
+			LastUpdatedTime: "2023-03-29 18:45:12",
+			CreationTime:    "2021-11-16 09:29:18",
+			Description:     "Built on a solidly designed foundation, Twenty Twenty-Two embraces the idea that everyone deserves a truly unique website.",
+			Sections:        FlexMap{"description": "Built on a solidly designed foundation, Twenty Twenty-Two embraces the idea that everyone deserves a truly unique website."},
+			DownloadLink:    "https://wp-mirror.blogvault.net/theme/twentytwentytwo.1.4.zip",
+			Tags:            FlexMap{"blog": "Blog", "block-patterns": "Block patterns", "full-site-editing": "Site editor"},
+			Versions:        FlexMap{"1.4": "https://wp-mirror.blogvault.net/theme/twentytwentytwo.1.4.zip"},
+			Requires:        "5.9",
+			RequiresPHP:     "5.6",
+		},
+	}
+
+	for _, info := range themeInfos {
+		err := store.SetThemeInfo(info)
+		if err != nil {
+			log.Printf("Error setting theme information for %s: %v", info.Slug, err)
+		}
+	}
+
 	fmt.Println("Dummy data populated successfully.")
 }
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errUpstreamNotFound is returned when upstream does not have an artifact
+var errUpstreamNotFound = errors.New("not found upstream")
+
+// fetchCall is an upstream fetch in progress
+type fetchCall struct {
+	done chan struct{}
+	err  error
+}
+
+// fetchGroup coalesces concurrent fetches of the same artifact
+type fetchGroup struct {
+	mu    sync.Mutex
+	calls map[string]*fetchCall
+}
+
+// join returns the fetch in progress for key, or starts one if there is
+// none, in which case the caller leads it and must call finish
+func (g *fetchGroup) join(key string) (call *fetchCall, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.calls == nil {
+		g.calls = make(map[string]*fetchCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		return call, false
+	}
+	call = &fetchCall{done: make(chan struct{})}
+	g.calls[key] = call
+	return call, true
+}
+
+// finish ends the fetch of key and wakes its waiters
+func (g *fetchGroup) finish(key string, call *fetchCall, err error) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	call.err = err
+	close(call.done)
+}
+
+// upstreamDownloadURL returns the downloads.wordpress.org URL of an artifact.
+// Localized core builds are release/<locale>/wordpress-<version>.zip upstream,
+// without the locale suffix of their stored name.
+func upstreamDownloadURL(a Artifact) string {
+	base := strings.TrimSuffix(cfg.Proxy.DownloadsURL, "/")
+	switch {
+	case a.Type == "core" && a.Locale != "":
+		return fmt.Sprintf("%s/release/%s/wordpress-%s.zip", base, a.Locale, a.Version)
+	case a.Type == "core":
+		return fmt.Sprintf("%s/release/%s", base, a.FileName())
+	default:
+		return fmt.Sprintf("%s/%s/%s", base, a.Type, a.FileName())
+	}
+}
+
+// serveZip serves a stored artifact. On a miss it is fetched from upstream
+// when cfg.Proxy.Enabled, streamed to the client and stored; concurrent
+// requests for it wait for that fetch and are served from the store.
+func (s *Server) serveZip(c *gin.Context, a Artifact) {
+	if err := a.validate(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	served, err := s.serveStoredZip(c, a)
+	if served {
+		return
+	}
+	if err != nil {
+		log.Printf("Error opening %s: %v", a, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+	if !cfg.Proxy.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	key := a.String()
+	call, leader := s.fetches.join(key)
+	if !leader {
+		<-call.done
+		if call.err != nil {
+			s.writeProxyError(c, call.err)
+			return
+		}
+		s.serveStoredZip(c, a)
+		return
+	}
+
+	// The previous fetch may have stored it since the lookup above
+	if _, err := s.artifacts.Stat(a); err == nil {
+		s.fetches.finish(key, call, nil)
+		s.serveStoredZip(c, a)
+		return
+	}
+
+	upstreamURL := upstreamDownloadURL(a)
+	if a.Type == "core" && cfg.Worker.VerifyCore {
+		// Core builds are verified before anyone is served them
+		err = s.fetchVerifiedCore(a, upstreamURL)
+		s.fetches.finish(key, call, err)
+		if err != nil {
+			log.Printf("Error fetching %s: %v", upstreamURL, err)
+			s.writeProxyError(c, err)
+			return
+		}
+		s.serveStoredZip(c, a)
+		return
+	}
+	err = s.proxyZip(c, a, upstreamURL)
+	s.fetches.finish(key, call, err)
+	if err != nil {
+		log.Printf("Error proxying %s: %v", upstreamURL, err)
+		if !c.Writer.Written() {
+			s.writeProxyError(c, err)
+		}
+	}
+}
+
+// serveStoredZip writes a stored artifact to the client, or redirects it to a
+// presigned URL when cfg.S3.Redirect, and reports whether it did; it is
+// false with a nil error if the artifact is not stored
+func (s *Server) serveStoredZip(c *gin.Context, a Artifact) (bool, error) {
+	if presigner, ok := s.artifacts.(ArtifactPresigner); ok && cfg.S3.Redirect {
+		return s.redirectZip(c, presigner, a)
+	}
+
+	r, info, err := s.artifacts.Open(a)
+	if errors.Is(err, ErrArtifactNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	c.Header("Content-Type", "application/zip")
+	if info.Digest != "" {
+		c.Header("ETag", `"sha256:`+info.Digest+`"`)
+	}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, a.FileName(), info.ModTime, rs)
+		return true, nil
+	}
+	c.Header("Content-Length", strconv.FormatInt(info.Size, 10))
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, r); err != nil {
+		log.Printf("Error serving %s: %v", a, err)
+	}
+	return true, nil
+}
+
+// redirectZip redirects the client to a presigned URL of a stored artifact.
+// The artifact is looked up first so misses can still be proxied.
+func (s *Server) redirectZip(c *gin.Context, presigner ArtifactPresigner, a Artifact) (bool, error) {
+	if _, err := s.artifacts.Stat(a); errors.Is(err, ErrArtifactNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	url, err := presigner.PresignGet(a, cfg.S3.PresignExpiry)
+	if err != nil {
+		return false, err
+	}
+	c.Redirect(http.StatusFound, url)
+	return true, nil
+}
+
+func (s *Server) writeProxyError(c *gin.Context, err error) {
+	if errors.Is(err, errUpstreamNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch file from upstream"})
+}
+
+// proxyZip streams upstreamURL to the client and into the artifact store,
+// committing the artifact once complete. The client going away does not
+// abort the fetch, so the artifact is still stored.
+func (s *Server) proxyZip(c *gin.Context, a Artifact, upstreamURL string) error {
+	resp, err := s.client.Get(upstreamURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errUpstreamNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	c.Header("Content-Type", "application/zip")
+	if resp.ContentLength >= 0 {
+		c.Header("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	c.Status(http.StatusOK)
+
+	staged, err := stageDownload(resp.Body, &clientWriter{w: c.Writer})
+	if err != nil {
+		return err
+	}
+	defer staged.discard()
+	if err := staged.validate(resp.ContentLength, nil); err != nil {
+		return err
+	}
+	if err := staged.publish(s.artifacts, a); err != nil {
+		return err
+	}
+	log.Printf("Stored %s from %s", a, upstreamURL)
+	return nil
+}
+
+// fetchVerifiedCore stores the upstream core build of an artifact once it
+// matches the published checksums
+func (s *Server) fetchVerifiedCore(a Artifact, upstreamURL string) error {
+	resp, err := s.client.Get(upstreamURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errUpstreamNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+	item := DownloadItem{Type: "core", Version: a.Version, Locale: a.Locale, URL: upstreamURL}
+	return NewDownloadWorkers(s.store, s.artifacts).downloadVerifiedCore(item, resp)
+}
+
+// clientWriter writes to the client until a write fails and then discards
+// the rest, so a disconnecting client does not abort the copy to disk
+type clientWriter struct {
+	w      io.Writer
+	failed bool
+}
+
+func (cw *clientWriter) Write(p []byte) (int, error) {
+	if !cw.failed {
+		if _, err := cw.w.Write(p); err != nil {
+			cw.failed = true
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupProxyConfig(t *testing.T, downloadsURL string) {
+	previous := cfg
+	t.Cleanup(func() { cfg = previous })
+	cfg = DefaultConfig()
+	dir := t.TempDir()
+	cfg.Paths.CoreDir = filepath.Join(dir, "core")
+	cfg.Paths.PluginsDir = filepath.Join(dir, "plugins")
+	cfg.Paths.ThemesDir = filepath.Join(dir, "themes")
+	cfg.Paths.StagingDir = filepath.Join(dir, "staging")
+	cfg.Proxy.Enabled = true
+	cfg.Proxy.DownloadsURL = downloadsURL
+}
+
+func TestDownloadServesStoredZip(t *testing.T) {
+	setupProxyConfig(t, "http://127.0.0.1:1")
+	cfg.Proxy.Enabled = false
+	require.NoError(t, os.MkdirAll(cfg.Paths.PluginsDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Paths.PluginsDir, "akismet.5.1.zip"), []byte("stored"), 0644))
+	router := NewServer(NewMemoryStore(), NewFileArtifactStore(cfg.Paths)).setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/plugins/akismet/5.1.zip", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "stored", w.Body.String())
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/plugins/akismet/5.0.zip", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestProxyCoalescesConcurrentMisses(t *testing.T) {
+	akismet := testZip(t, "akismet 5.1")
+	var hits atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path != "/plugin/akismet.5.1.zip" {
+			http.NotFound(w, r)
+			return
+		}
+		<-release
+		w.Write(akismet)
+	}))
+	defer upstream.Close()
+	setupProxyConfig(t, upstream.URL)
+	router := NewServer(NewMemoryStore(), NewFileArtifactStore(cfg.Paths)).setupRouter()
+
+	var wg sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, 5)
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "/plugins/akismet/5.1.zip", nil)
+			router.ServeHTTP(w, req)
+		}(recorders[i])
+	}
+	// Let every request reach the server before upstream answers
+	require.Eventually(t, func() bool { return hits.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), hits.Load())
+	for _, w := range recorders {
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, akismet, w.Body.Bytes())
+	}
+	stored, err := os.ReadFile(filepath.Join(cfg.Paths.PluginsDir, "akismet.5.1.zip"))
+	require.NoError(t, err)
+	assert.Equal(t, akismet, stored)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/themes/missing/1.0.zip", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+	entries, err := os.ReadDir(cfg.Paths.PluginsDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestProxyFetchesLocalizedCore(t *testing.T) {
+	var paths []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path != "/release/de_DE/wordpress-6.4.2.zip" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(testZip(t, "wordpress de_DE"))
+	}))
+	defer upstream.Close()
+	setupProxyConfig(t, upstream.URL)
+	cfg.Worker.VerifyCore = false
+	router := NewServer(NewMemoryStore(), NewFileArtifactStore(cfg.Paths)).setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/core/6.4.2-de_DE.zip", nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+	assert.Equal(t, testZip(t, "wordpress de_DE"), w.Body.Bytes())
+	assert.Equal(t, []string{"/release/de_DE/wordpress-6.4.2.zip"}, paths)
+
+	// Stored under the mirror's name, with the locale suffix
+	stored, err := os.ReadFile(filepath.Join(cfg.Paths.CoreDir, "wordpress-6.4.2-de_DE.zip"))
+	require.NoError(t, err)
+	assert.Equal(t, testZip(t, "wordpress de_DE"), stored)
+}
@@ -1,62 +1,56 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"time"
+
 	"github.com/go-redis/redis/v8"
-	"context"
-	"errors"
 )
 
-var ctx = context.Background()
-
-// Redis client
-var rdb *redis.Client
-
-// Structs for storing WordPress information
-type CoreVersion struct {
-	Version       string `json:"version"`
-	PHPVersion    string `json:"php_version"`
-	MySQLVersion  string `json:"mysql_version"`
-	NewBundled    string `json:"new_bundled"`
-	PartialVersion bool   `json:"partial_version"`
-	Package       string `json:"package"`
-	Current       string `json:"current"`
-	Locale        string `json:"locale"`
-}
+const (
+	downloadQueue      = "download_queue"
+	downloadStatesKey  = "download_states"
+	pluginInfoKey      = "plugin_info"
+	pluginSummariesKey = "plugin_summaries"
+	themeInfoKey       = "theme_info"
+	themeSummariesKey  = "theme_summaries"
+	crawlCursorsKey    = "crawl_cursors"
+	rollbacksKey       = "rollbacks"
+	artifactDigestsKey = "artifact_digests"
+	artifactKeysKey    = "artifact_keys"
+)
 
-type PluginVersion struct {
-	Slug       string `json:"slug"`
-	NewVersion string `json:"new_version"`
-	URL        string `json:"url"`
-	Package    string `json:"package"`
-}
+var ctx = context.Background()
 
-type ThemeVersion struct {
-	Theme      string `json:"theme"`
-	NewVersion string `json:"new_version"`
-	URL        string `json:"url"`
-	Package    string `json:"package"`
+// RedisStore is the MetadataStore backed by Redis hashes and lists
+type RedisStore struct {
+	rdb *redis.Client
 }
 
 // InitRedis initializes the Redis connection
-func InitRedis(addr string) error {
-	rdb = redis.NewClient(&redis.Options{
+func InitRedis(addr string) (*RedisStore, error) {
+	rdb := redis.NewClient(&redis.Options{
 		Addr: addr,
 	})
 
 	_, err := rdb.Ping(ctx).Result()
-	return err
+	if err != nil {
+		return nil, err
+	}
+	return &RedisStore{rdb: rdb}, nil
 }
 
 // SetCoreVersions sets the list of core version information
-func SetCoreVersions(versions []CoreVersion) error {
+func (s *RedisStore) SetCoreVersions(versions []CoreVersion) error {
 	for _, v := range versions {
 		jsonData, err := json.Marshal(v)
 		if err != nil {
 			return err
 		}
-		err = rdb.HSet(ctx, "core_versions", v.Version, jsonData).Err()
+		err = s.rdb.HSet(ctx, "core_versions", v.key(), jsonData).Err()
 		if err != nil {
 			return err
 		}
@@ -65,8 +59,8 @@ func SetCoreVersions(versions []CoreVersion) error {
 }
 
 // GetCoreVersions gets the list of core version information
-func GetCoreVersions() ([]CoreVersion, error) {
-	data, err := rdb.HGetAll(ctx, "core_versions").Result()
+func (s *RedisStore) GetCoreVersions() ([]CoreVersion, error) {
+	data, err := s.rdb.HGetAll(ctx, "core_versions").Result()
 	if err != nil {
 		return nil, err
 	}
@@ -84,14 +78,14 @@ func GetCoreVersions() ([]CoreVersion, error) {
 }
 
 // SetPluginVersions sets the list of plugin version information for a given plugin file
-func SetPluginVersions(pluginFile string, versions []PluginVersion) error {
+func (s *RedisStore) SetPluginVersions(pluginFile string, versions []PluginVersion) error {
 	key := fmt.Sprintf("plugins:%s", pluginFile)
 	for _, v := range versions {
 		jsonData, err := json.Marshal(v)
 		if err != nil {
 			return err
 		}
-		err = rdb.HSet(ctx, key, v.NewVersion, jsonData).Err()
+		err = s.rdb.HSet(ctx, key, v.NewVersion, jsonData).Err()
 		if err != nil {
 			return err
 		}
@@ -100,9 +94,9 @@ func SetPluginVersions(pluginFile string, versions []PluginVersion) error {
 }
 
 // GetPluginVersions gets the list of plugin version information for a given plugin file
-func GetPluginVersions(pluginFile string) ([]PluginVersion, error) {
+func (s *RedisStore) GetPluginVersions(pluginFile string) ([]PluginVersion, error) {
 	key := fmt.Sprintf("plugins:%s", pluginFile)
-	data, err := rdb.HGetAll(ctx, key).Result()
+	data, err := s.rdb.HGetAll(ctx, key).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -119,15 +113,23 @@ func GetPluginVersions(pluginFile string) ([]PluginVersion, error) {
 	return versions, nil
 }
 
+// DeletePluginVersions removes versions from the plugin file's hash
+func (s *RedisStore) DeletePluginVersions(pluginFile string, versions []string) error {
+	if len(versions) == 0 {
+		return nil
+	}
+	return s.rdb.HDel(ctx, fmt.Sprintf("plugins:%s", pluginFile), versions...).Err()
+}
+
 // SetThemeVersions sets the list of theme version information for a given theme slug
-func SetThemeVersions(themeSlug string, versions []ThemeVersion) error {
+func (s *RedisStore) SetThemeVersions(themeSlug string, versions []ThemeVersion) error {
 	key := fmt.Sprintf("themes:%s", themeSlug)
 	for _, v := range versions {
 		jsonData, err := json.Marshal(v)
 		if err != nil {
 			return err
 		}
-		err = rdb.HSet(ctx, key, v.NewVersion, jsonData).Err()
+		err = s.rdb.HSet(ctx, key, v.NewVersion, jsonData).Err()
 		if err != nil {
 			return err
 		}
@@ -136,9 +138,9 @@ func SetThemeVersions(themeSlug string, versions []ThemeVersion) error {
 }
 
 // GetThemeVersions gets the list of theme version information for a given theme slug
-func GetThemeVersions(themeSlug string) ([]ThemeVersion, error) {
+func (s *RedisStore) GetThemeVersions(themeSlug string) ([]ThemeVersion, error) {
 	key := fmt.Sprintf("themes:%s", themeSlug)
-	data, err := rdb.HGetAll(ctx, key).Result()
+	data, err := s.rdb.HGetAll(ctx, key).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -155,9 +157,17 @@ func GetThemeVersions(themeSlug string) ([]ThemeVersion, error) {
 	return versions, nil
 }
 
+// DeleteThemeVersions removes versions from the theme slug's hash
+func (s *RedisStore) DeleteThemeVersions(themeSlug string, versions []string) error {
+	if len(versions) == 0 {
+		return nil
+	}
+	return s.rdb.HDel(ctx, fmt.Sprintf("themes:%s", themeSlug), versions...).Err()
+}
+
 // ListAllPluginFiles lists all stored plugin files
-func ListAllPluginFiles() ([]string, error) {
-	keys, err := rdb.Keys(ctx, "plugins:*").Result()
+func (s *RedisStore) ListAllPluginFiles() ([]string, error) {
+	keys, err := s.rdb.Keys(ctx, "plugins:*").Result()
 	if err != nil {
 		return nil, err
 	}
@@ -170,8 +180,8 @@ func ListAllPluginFiles() ([]string, error) {
 }
 
 // ListAllThemeSlugs lists all stored theme slugs
-func ListAllThemeSlugs() ([]string, error) {
-	keys, err := rdb.Keys(ctx, "themes:*").Result()
+func (s *RedisStore) ListAllThemeSlugs() ([]string, error) {
+	keys, err := s.rdb.Keys(ctx, "themes:*").Result()
 	if err != nil {
 		return nil, err
 	}
@@ -184,20 +194,20 @@ func ListAllThemeSlugs() ([]string, error) {
 }
 
 // GetLatestPluginVersion gets the latest plugin version information for a given plugin file
-func GetLatestPluginVersion(pluginFile string) (*PluginVersion, error) {
+func (s *RedisStore) GetLatestPluginVersion(pluginFile string) (*PluginVersion, error) {
 	key := fmt.Sprintf("plugins:%s", pluginFile)
-	versions, err := rdb.HGetAll(ctx, key).Result()
+	versions, err := s.rdb.HGetAll(ctx, key).Result()
 	if err != nil {
 		return nil, err
 	}
 	if len(versions) == 0 {
-		return nil, errors.New("no versions found for the plugin")
+		return nil, ErrNoPluginVersions
 	}
 
 	var latestVersion PluginVersion
 	var latestVersionNumber string
 	for version, data := range versions {
-		if version > latestVersionNumber {
+		if CompareVersions(version, latestVersionNumber) > 0 {
 			latestVersionNumber = version
 			err := json.Unmarshal([]byte(data), &latestVersion)
 			if err != nil {
@@ -209,20 +219,20 @@ func GetLatestPluginVersion(pluginFile string) (*PluginVersion, error) {
 }
 
 // GetLatestThemeVersion gets the latest theme version information for a given theme slug
-func GetLatestThemeVersion(themeSlug string) (*ThemeVersion, error) {
+func (s *RedisStore) GetLatestThemeVersion(themeSlug string) (*ThemeVersion, error) {
 	key := fmt.Sprintf("themes:%s", themeSlug)
-	versions, err := rdb.HGetAll(ctx, key).Result()
+	versions, err := s.rdb.HGetAll(ctx, key).Result()
 	if err != nil {
 		return nil, err
 	}
 	if len(versions) == 0 {
-		return nil, errors.New("no versions found for the theme")
+		return nil, ErrNoThemeVersions
 	}
 
 	var latestVersion ThemeVersion
 	var latestVersionNumber string
 	for version, data := range versions {
-		if version > latestVersionNumber {
+		if CompareVersions(version, latestVersionNumber) > 0 {
 			latestVersionNumber = version
 			err := json.Unmarshal([]byte(data), &latestVersion)
 			if err != nil {
@@ -232,3 +242,431 @@ func GetLatestThemeVersion(themeSlug string) (*ThemeVersion, error) {
 	}
 	return &latestVersion, nil
 }
+
+// SetPluginInfo stores the full record in the plugin_info hash and its
+// summary in the plugin_summaries hash, so queries need not load the
+// sections of every plugin
+func (s *RedisStore) SetPluginInfo(info PluginInfo) error {
+	fullData, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	summaryData, err := json.Marshal(info.Summary())
+	if err != nil {
+		return err
+	}
+
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, pluginInfoKey, info.Slug, fullData)
+		pipe.HSet(ctx, pluginSummariesKey, info.Slug, summaryData)
+		return nil
+	})
+	return err
+}
+
+// GetPluginInfo gets the full plugin record for a given slug
+func (s *RedisStore) GetPluginInfo(slug string) (*PluginInfo, error) {
+	data, err := s.rdb.HGet(ctx, pluginInfoKey, slug).Result()
+	if err == redis.Nil {
+		return nil, ErrPluginInfoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var info PluginInfo
+	err = json.Unmarshal([]byte(data), &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// ListPluginSummaries lists the summaries of all stored plugin records
+func (s *RedisStore) ListPluginSummaries() ([]PluginInfo, error) {
+	data, err := s.rdb.HGetAll(ctx, pluginSummariesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]PluginInfo, 0, len(data))
+	for _, v := range data {
+		var info PluginInfo
+		err := json.Unmarshal([]byte(v), &info)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, info)
+	}
+	return summaries, nil
+}
+
+// SetThemeInfo stores the full record in the theme_info hash and its
+// summary in the theme_summaries hash
+func (s *RedisStore) SetThemeInfo(info ThemeInfo) error {
+	fullData, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	summaryData, err := json.Marshal(info.Summary())
+	if err != nil {
+		return err
+	}
+
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, themeInfoKey, info.Slug, fullData)
+		pipe.HSet(ctx, themeSummariesKey, info.Slug, summaryData)
+		return nil
+	})
+	return err
+}
+
+// GetThemeInfo gets the full theme record for a given slug
+func (s *RedisStore) GetThemeInfo(slug string) (*ThemeInfo, error) {
+	data, err := s.rdb.HGet(ctx, themeInfoKey, slug).Result()
+	if err == redis.Nil {
+		return nil, ErrThemeInfoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var info ThemeInfo
+	err = json.Unmarshal([]byte(data), &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// ListThemeSummaries lists the summaries of all stored theme records
+func (s *RedisStore) ListThemeSummaries() ([]ThemeInfo, error) {
+	data, err := s.rdb.HGetAll(ctx, themeSummariesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]ThemeInfo, 0, len(data))
+	for _, v := range data {
+		var info ThemeInfo
+		err := json.Unmarshal([]byte(v), &info)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, info)
+	}
+	return summaries, nil
+}
+
+// PushDownload appends an item to the download queue list
+func (s *RedisStore) PushDownload(item DownloadItem) error {
+	jsonData, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return s.rdb.RPush(ctx, downloadQueue, jsonData).Err()
+}
+
+// PopDownload blocks until an item is available in the download queue list
+func (s *RedisStore) PopDownload() (DownloadItem, error) {
+	var item DownloadItem
+	result, err := s.rdb.BLPop(ctx, 0, downloadQueue).Result()
+	if err != nil {
+		return item, err
+	}
+	err = json.Unmarshal([]byte(result[1]), &item)
+	return item, err
+}
+
+// SetDownloadState stores the state of an item in the download_states hash
+func (s *RedisStore) SetDownloadState(state DownloadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, downloadStatesKey, state.Key, data).Err()
+}
+
+// ListDownloadStates lists the download_states hash
+func (s *RedisStore) ListDownloadStates() ([]DownloadState, error) {
+	data, err := s.rdb.HGetAll(ctx, downloadStatesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]DownloadState, 0, len(data))
+	for _, v := range data {
+		var state DownloadState
+		err := json.Unmarshal([]byte(v), &state)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Key < states[j].Key })
+	return states, nil
+}
+
+// DeleteDownloadState removes an item from the download_states hash
+func (s *RedisStore) DeleteDownloadState(key string) error {
+	return s.rdb.HDel(ctx, downloadStatesKey, key).Err()
+}
+
+// GetCrawlCursor gets the named crawl cursor from the crawl_cursors hash
+func (s *RedisStore) GetCrawlCursor(name string) (*CrawlCursor, error) {
+	var cursor CrawlCursor
+	data, err := s.rdb.HGet(ctx, crawlCursorsKey, name).Result()
+	if err == redis.Nil {
+		return &cursor, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(data), &cursor)
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// SetCrawlCursor stores the named crawl cursor in the crawl_cursors hash
+func (s *RedisStore) SetCrawlCursor(name string, cursor CrawlCursor) error {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, crawlCursorsKey, name, data).Err()
+}
+
+// RecordDemand adds the slug to the demands:<kind> hash unless present
+func (s *RedisStore) RecordDemand(kind, slug string) error {
+	data, err := json.Marshal(Demand{Slug: slug, RequestedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSetNX(ctx, "demands:"+kind, slug, data).Err()
+}
+
+// ListDemands lists the demands:<kind> hash
+func (s *RedisStore) ListDemands(kind string) ([]Demand, error) {
+	data, err := s.rdb.HGetAll(ctx, "demands:"+kind).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	demands := make([]Demand, 0, len(data))
+	for _, v := range data {
+		var d Demand
+		err := json.Unmarshal([]byte(v), &d)
+		if err != nil {
+			return nil, err
+		}
+		demands = append(demands, d)
+	}
+	sort.Slice(demands, func(i, j int) bool { return demands[i].Slug < demands[j].Slug })
+	return demands, nil
+}
+
+// SetDemand stores a demand in the demands:<kind> hash
+func (s *RedisStore) SetDemand(kind string, demand Demand) error {
+	data, err := json.Marshal(demand)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, "demands:"+kind, demand.Slug, data).Err()
+}
+
+// DeleteDemand removes a slug from the demands:<kind> hash
+func (s *RedisStore) DeleteDemand(kind, slug string) error {
+	return s.rdb.HDel(ctx, "demands:"+kind, slug).Err()
+}
+
+// SetRollback stores a rollback in the rollbacks hash under <type>:<slug>
+func (s *RedisStore) SetRollback(rollback Rollback) error {
+	data, err := json.Marshal(rollback)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, rollbacksKey, rollback.key(), data).Err()
+}
+
+// GetRollback gets a rollback from the rollbacks hash
+func (s *RedisStore) GetRollback(kind, slug string) (*Rollback, error) {
+	data, err := s.rdb.HGet(ctx, rollbacksKey, rollbackKey(kind, slug)).Result()
+	if err == redis.Nil {
+		return nil, ErrRollbackNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rollback Rollback
+	err = json.Unmarshal([]byte(data), &rollback)
+	if err != nil {
+		return nil, err
+	}
+	return &rollback, nil
+}
+
+// ListRollbacks lists the rollbacks hash
+func (s *RedisStore) ListRollbacks() ([]Rollback, error) {
+	data, err := s.rdb.HGetAll(ctx, rollbacksKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	rollbacks := make([]Rollback, 0, len(data))
+	for _, v := range data {
+		var rollback Rollback
+		err := json.Unmarshal([]byte(v), &rollback)
+		if err != nil {
+			return nil, err
+		}
+		rollbacks = append(rollbacks, rollback)
+	}
+	sort.Slice(rollbacks, func(i, j int) bool { return rollbacks[i].key() < rollbacks[j].key() })
+	return rollbacks, nil
+}
+
+// DeleteRollback removes a rollback from the rollbacks hash
+func (s *RedisStore) DeleteRollback(kind, slug string) error {
+	return s.rdb.HDel(ctx, rollbacksKey, rollbackKey(kind, slug)).Err()
+}
+
+// SetArtifactDigest maps an artifact key to a digest in the
+// artifact_digests hash, indexes the key in the artifact_keys sorted set and
+// adds it to the blob_refs:<digest> set
+func (s *RedisStore) SetArtifactDigest(key, digest string) (string, error) {
+	var old string
+	var remaining *redis.IntCmd
+	err := s.watchDigests(func(tx *redis.Tx) error {
+		var err error
+		old, err = tx.HGet(ctx, artifactDigestsKey, key).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+
+		remaining = nil
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, artifactDigestsKey, key, digest)
+			pipe.ZAdd(ctx, artifactKeysKey, &redis.Z{Member: key})
+			pipe.SAdd(ctx, blobRefsKey(digest), key)
+			if old != "" && old != digest {
+				pipe.SRem(ctx, blobRefsKey(old), key)
+				remaining = pipe.SCard(ctx, blobRefsKey(old))
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if remaining != nil && remaining.Val() == 0 {
+		return old, nil
+	}
+	return "", nil
+}
+
+// GetArtifactDigest gets the digest of an artifact key from the
+// artifact_digests hash
+func (s *RedisStore) GetArtifactDigest(key string) (string, error) {
+	digest, err := s.rdb.HGet(ctx, artifactDigestsKey, key).Result()
+	if err == redis.Nil {
+		return "", ErrArtifactNotFound
+	}
+	return digest, err
+}
+
+// ListArtifactDigests looks up the keys starting with prefix in the
+// artifact_keys sorted set and returns their digests
+func (s *RedisStore) ListArtifactDigests(prefix string) (map[string]string, error) {
+	keys, err := s.rdb.ZRangeByLex(ctx, artifactKeysKey, &redis.ZRangeBy{
+		Min: "[" + prefix,
+		Max: "(" + prefix + "\xff",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	digests := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return digests, nil
+	}
+	values, err := s.rdb.HMGet(ctx, artifactDigestsKey, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		if digest, ok := v.(string); ok {
+			digests[keys[i]] = digest
+		}
+	}
+	return digests, nil
+}
+
+// DeleteArtifactDigest removes the mapping of an artifact key and its
+// reference of the digest
+func (s *RedisStore) DeleteArtifactDigest(key string) (string, error) {
+	var digest string
+	var remaining *redis.IntCmd
+	err := s.watchDigests(func(tx *redis.Tx) error {
+		var err error
+		digest, err = tx.HGet(ctx, artifactDigestsKey, key).Result()
+		if err == redis.Nil {
+			digest, remaining = "", nil
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, artifactDigestsKey, key)
+			pipe.ZRem(ctx, artifactKeysKey, key)
+			pipe.SRem(ctx, blobRefsKey(digest), key)
+			remaining = pipe.SCard(ctx, blobRefsKey(digest))
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if remaining != nil && remaining.Val() == 0 {
+		return digest, nil
+	}
+	return "", nil
+}
+
+// maxWatchRetries bounds the attempts of a transaction aborted by concurrent
+// changes to the keys it watches
+const maxWatchRetries = 10
+
+// watchDigests runs fn as a transaction watching the artifact_digests hash,
+// so the digest it reads cannot change before its commands run. It is
+// retried when a concurrent change aborts it.
+func (s *RedisStore) watchDigests(fn func(tx *redis.Tx) error) error {
+	for i := 0; i < maxWatchRetries; i++ {
+		err := s.rdb.Watch(ctx, fn, artifactDigestsKey)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("error updating %s: too many concurrent changes", artifactDigestsKey)
+}
+
+func blobRefsKey(digest string) string {
+	return "blob_refs:" + digest
+}
+
+// AcquireLock sets the lock key if it does not exist yet
+func (s *RedisStore) AcquireLock(key string, ttl time.Duration) (bool, error) {
+	return s.rdb.SetNX(ctx, key, "locked", ttl).Result()
+}
+
+// ReleaseLock deletes the lock key
+func (s *RedisStore) ReleaseLock(key string) error {
+	return s.rdb.Del(ctx, key).Err()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// pluginHistory returns the releases of a plugin record to keep, newest
+// first: the current version and the in-scope versions of its versions map,
+// up to cfg.Retention.Versions. Requirements and the release date are only
+// known for the current version, so older releases leave them empty.
+func pluginHistory(info PluginInfo) []PluginVersion {
+	current := info.PluginVersion()
+	history := []PluginVersion{current}
+	for version, pkg := range info.Versions {
+		if version == "trunk" || version == current.NewVersion || !cfg.Scope.Plugins.AllowsVersion(info.Slug, version) {
+			continue
+		}
+		release := current
+		release.NewVersion = version
+		release.Package = pkg
+		release.Requires, release.Tested, release.RequiresPHP = "", "", ""
+		release.Released = ""
+		history = append(history, release)
+	}
+
+	sort.Slice(history, func(i, j int) bool {
+		return CompareVersions(history[i].NewVersion, history[j].NewVersion) > 0
+	})
+	if len(history) > cfg.Retention.Versions {
+		history = history[:cfg.Retention.Versions]
+	}
+	return history
+}
+
+// themeHistory returns the releases of a theme record to keep, newest first,
+// like pluginHistory
+func themeHistory(info ThemeInfo) []ThemeVersion {
+	current := info.ThemeVersion()
+	history := []ThemeVersion{current}
+	for version, pkg := range info.Versions {
+		if version == "trunk" || version == current.NewVersion || !cfg.Scope.Themes.AllowsVersion(info.Slug, version) {
+			continue
+		}
+		release := current
+		release.NewVersion = version
+		release.Package = pkg
+		release.Requires, release.RequiresPHP = "", ""
+		release.Released = ""
+		history = append(history, release)
+	}
+
+	sort.Slice(history, func(i, j int) bool {
+		return CompareVersions(history[i].NewVersion, history[j].NewVersion) > 0
+	})
+	if len(history) > cfg.Retention.Versions {
+		history = history[:cfg.Retention.Versions]
+	}
+	return history
+}
+
+// expiredVersions returns the versions beyond the cfg.Retention.Versions
+// newest ones, except the rolledBack version
+func expiredVersions(versions []string, rolledBack string) []string {
+	sorted := append([]string{}, versions...)
+	sort.Slice(sorted, func(i, j int) bool { return CompareVersions(sorted[i], sorted[j]) > 0 })
+	if len(sorted) <= cfg.Retention.Versions {
+		return nil
+	}
+	var expired []string
+	for _, version := range sorted[cfg.Retention.Versions:] {
+		if version != rolledBack {
+			expired = append(expired, version)
+		}
+	}
+	return expired
+}
+
+// rolledBackVersion returns the version an active rollback of a plugin or
+// theme slug offers, which is kept however old it is, or "" if there is none
+func rolledBackVersion(store MetadataStore, kind, slug string) (string, error) {
+	rollback, err := store.GetRollback(kind, slug)
+	if errors.Is(err, ErrRollbackNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error fetching rollback for %s %s: %w", kind, slug, err)
+	}
+	return rollback.Version, nil
+}
+
+// prunePluginVersions removes the expired releases of a plugin file from the
+// store and returns the remaining ones. The release of an active rollback
+// does not expire.
+func prunePluginVersions(store MetadataStore, pluginFile string) ([]PluginVersion, error) {
+	versions, err := store.GetPluginVersions(pluginFile)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching plugin versions for %s: %w", pluginFile, err)
+	}
+	names := make([]string, len(versions))
+	for i, v := range versions {
+		names[i] = v.NewVersion
+	}
+	rolledBack, err := rolledBackVersion(store, "plugin", pluginSlug(pluginFile))
+	if err != nil {
+		return nil, err
+	}
+
+	expired := expiredVersions(names, rolledBack)
+	if len(expired) == 0 {
+		return versions, nil
+	}
+	log.Printf("Pruning plugin %s versions %s", pluginFile, strings.Join(expired, ", "))
+	if err := store.DeletePluginVersions(pluginFile, expired); err != nil {
+		return nil, fmt.Errorf("error pruning plugin versions for %s: %w", pluginFile, err)
+	}
+	return store.GetPluginVersions(pluginFile)
+}
+
+// pruneThemeVersions removes the expired releases of a theme from the store
+// and returns the remaining ones, like prunePluginVersions
+func pruneThemeVersions(store MetadataStore, themeSlug string) ([]ThemeVersion, error) {
+	versions, err := store.GetThemeVersions(themeSlug)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching theme versions for %s: %w", themeSlug, err)
+	}
+	names := make([]string, len(versions))
+	for i, v := range versions {
+		names[i] = v.NewVersion
+	}
+	rolledBack, err := rolledBackVersion(store, "theme", themeSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := expiredVersions(names, rolledBack)
+	if len(expired) == 0 {
+		return versions, nil
+	}
+	log.Printf("Pruning theme %s versions %s", themeSlug, strings.Join(expired, ", "))
+	if err := store.DeleteThemeVersions(themeSlug, expired); err != nil {
+		return nil, fmt.Errorf("error pruning theme versions for %s: %w", themeSlug, err)
+	}
+	return store.GetThemeVersions(themeSlug)
+}
+
+// pruneZips removes the stored artifacts of a plugin or theme slug whose
+// version is not kept
+func pruneZips(artifacts ArtifactStore, kind, slug string, kept map[string]bool) error {
+	versions, err := artifacts.Versions(kind, slug)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if kept[version] {
+			continue
+		}
+		a := Artifact{Type: kind, Slug: slug, Version: version}
+		log.Printf("Removing expired release %s", a)
+		if err := artifacts.Delete(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyRetention prunes the releases of every plugin and theme beyond the
+// newest cfg.Retention.Versions from the store and the artifact store,
+// keeping those active rollbacks offer
+func (dc *DownloadChecker) applyRetention() error {
+	pluginFiles, err := dc.store.ListAllPluginFiles()
+	if err != nil {
+		return fmt.Errorf("error listing plugin files: %w", err)
+	}
+	// Plugins can be stored under their file and their slug, which share
+	// the zips of the slug
+	kept := make(map[string]map[string]bool)
+	for _, pluginFile := range pluginFiles {
+		versions, err := prunePluginVersions(dc.store, pluginFile)
+		if err != nil {
+			return err
+		}
+		slug := pluginSlug(pluginFile)
+		if kept[slug] == nil {
+			kept[slug] = make(map[string]bool)
+		}
+		for _, v := range versions {
+			kept[slug][v.NewVersion] = true
+		}
+	}
+	for slug, versions := range kept {
+		if err := pruneZips(dc.artifacts, "plugin", slug, versions); err != nil {
+			return fmt.Errorf("error pruning plugin %s zips: %w", slug, err)
+		}
+	}
+
+	themeSlugs, err := dc.store.ListAllThemeSlugs()
+	if err != nil {
+		return fmt.Errorf("error listing theme slugs: %w", err)
+	}
+	for _, themeSlug := range themeSlugs {
+		versions, err := pruneThemeVersions(dc.store, themeSlug)
+		if err != nil {
+			return err
+		}
+		kept := make(map[string]bool)
+		for _, v := range versions {
+			kept[v.NewVersion] = true
+		}
+		if err := pruneZips(dc.artifacts, "theme", themeSlug, kept); err != nil {
+			return fmt.Errorf("error pruning theme %s zips: %w", themeSlug, err)
+		}
+	}
+	return nil
+}
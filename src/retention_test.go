@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pluginVersionNames(t *testing.T, store MetadataStore, pluginFile string) []string {
+	versions, err := store.GetPluginVersions(pluginFile)
+	require.NoError(t, err)
+	var names []string
+	for _, v := range versions {
+		names = append(names, v.NewVersion)
+	}
+	sort.Slice(names, func(i, j int) bool { return CompareVersions(names[i], names[j]) > 0 })
+	return names
+}
+
+func TestStorePluginInfoKeepsHistory(t *testing.T) {
+	previous := cfg
+	t.Cleanup(func() { cfg = previous })
+	cfg = DefaultConfig()
+	cfg.Retention.Versions = 3
+
+	store := NewMemoryStore()
+	info := PluginInfo{
+		Slug:         "akismet",
+		Version:      "5.3",
+		DownloadLink: "https://downloads.wordpress.org/plugin/akismet.5.3.zip",
+		Versions: FlexMap{
+			"5.0":   "https://downloads.wordpress.org/plugin/akismet.5.0.zip",
+			"5.1":   "https://downloads.wordpress.org/plugin/akismet.5.1.zip",
+			"5.2":   "https://downloads.wordpress.org/plugin/akismet.5.2.zip",
+			"5.3":   "https://downloads.wordpress.org/plugin/akismet.5.3.zip",
+			"trunk": "https://downloads.wordpress.org/plugin/akismet.zip",
+		},
+	}
+	require.NoError(t, storePluginInfo(store, info))
+	assert.Equal(t, []string{"5.3", "5.2", "5.1"}, pluginVersionNames(t, store, "akismet"))
+
+	versions, err := store.GetPluginVersions("akismet")
+	require.NoError(t, err)
+	for _, v := range versions {
+		if v.NewVersion == "5.2" {
+			assert.Equal(t, "https://downloads.wordpress.org/plugin/akismet.5.2.zip", v.Package)
+		}
+	}
+
+	// A new release pushes the oldest one out
+	info.Version = "5.4"
+	info.Versions["5.4"] = "https://downloads.wordpress.org/plugin/akismet.5.4.zip"
+	require.NoError(t, storePluginInfo(store, info))
+	assert.Equal(t, []string{"5.4", "5.3", "5.2"}, pluginVersionNames(t, store, "akismet"))
+}
+
+func TestApplyRetentionPrunesZips(t *testing.T) {
+	previous := cfg
+	t.Cleanup(func() { cfg = previous })
+	cfg = DefaultConfig()
+	cfg.Paths.PluginsDir = t.TempDir()
+	cfg.Retention.Versions = 2
+
+	store := NewMemoryStore()
+	require.NoError(t, store.SetPluginVersions("akismet", []PluginVersion{
+		{Slug: "akismet", NewVersion: "5.1"},
+		{Slug: "akismet", NewVersion: "5.2"},
+		{Slug: "akismet", NewVersion: "5.3"},
+	}))
+	for _, name := range []string{"akismet.5.1.zip", "akismet.5.2.zip", "akismet.5.3.zip", "akismet-extra.1.0.zip"} {
+		require.NoError(t, os.WriteFile(filepath.Join(cfg.Paths.PluginsDir, name), []byte("zip"), 0644))
+	}
+
+	require.NoError(t, NewDownloadChecker(store, NewFileArtifactStore(cfg.Paths)).applyRetention())
+	assert.Equal(t, []string{"5.3", "5.2"}, pluginVersionNames(t, store, "akismet"))
+
+	entries, err := os.ReadDir(cfg.Paths.PluginsDir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.ElementsMatch(t, []string{"akismet.5.2.zip", "akismet.5.3.zip", "akismet-extra.1.0.zip"}, names)
+}
+
+func TestRetentionKeepsRolledBackReleases(t *testing.T) {
+	previous := cfg
+	t.Cleanup(func() { cfg = previous })
+	cfg = DefaultConfig()
+	cfg.Paths.PluginsDir = t.TempDir()
+	cfg.Paths.ThemesDir = t.TempDir()
+	cfg.Retention.Versions = 2
+
+	store := NewMemoryStore()
+	require.NoError(t, store.SetRollback(Rollback{Type: "plugin", Slug: "akismet", Version: "5.1"}))
+	require.NoError(t, store.SetRollback(Rollback{Type: "theme", Slug: "twentytwentyfour", Version: "1.0"}))
+	require.NoError(t, store.SetPluginVersions("akismet/akismet.php", []PluginVersion{
+		{Slug: "akismet", NewVersion: "5.0"},
+		{Slug: "akismet", NewVersion: "5.1"},
+		{Slug: "akismet", NewVersion: "5.2"},
+		{Slug: "akismet", NewVersion: "5.3"},
+	}))
+	require.NoError(t, store.SetThemeVersions("twentytwentyfour", []ThemeVersion{
+		{Theme: "twentytwentyfour", NewVersion: "1.0"},
+		{Theme: "twentytwentyfour", NewVersion: "1.1"},
+		{Theme: "twentytwentyfour", NewVersion: "1.2"},
+	}))
+	for _, name := range []string{"akismet.5.0.zip", "akismet.5.1.zip", "akismet.5.2.zip", "akismet.5.3.zip"} {
+		require.NoError(t, os.WriteFile(filepath.Join(cfg.Paths.PluginsDir, name), []byte("zip"), 0644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Paths.ThemesDir, "twentytwentyfour.1.0.zip"), []byte("zip"), 0644))
+
+	artifacts := NewFileArtifactStore(cfg.Paths)
+	require.NoError(t, NewDownloadChecker(store, artifacts).applyRetention())
+	assert.Equal(t, []string{"5.3", "5.2", "5.1"}, pluginVersionNames(t, store, "akismet/akismet.php"))
+	versions, err := artifacts.Versions("plugin", "akismet")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"5.1", "5.2", "5.3"}, versions)
+	themes, err := store.GetThemeVersions("twentytwentyfour")
+	require.NoError(t, err)
+	assert.Len(t, themes, 3)
+	assert.FileExists(t, filepath.Join(cfg.Paths.ThemesDir, "twentytwentyfour.1.0.zip"))
+
+	// New releases from the crawler do not push it out either
+	require.NoError(t, store.SetPluginVersions("akismet", []PluginVersion{
+		{Slug: "akismet", NewVersion: "5.1"},
+		{Slug: "akismet", NewVersion: "5.2"},
+		{Slug: "akismet", NewVersion: "5.3"},
+	}))
+	info := PluginInfo{Slug: "akismet", Version: "5.4", Versions: FlexMap{"5.4": "https://downloads.wordpress.org/plugin/akismet.5.4.zip"}}
+	require.NoError(t, storePluginInfo(store, info))
+	assert.Equal(t, []string{"5.4", "5.3", "5.1"}, pluginVersionNames(t, store, "akismet"))
+
+	// Once the rollback is lifted the release expires
+	require.NoError(t, store.DeleteRollback("plugin", "akismet"))
+	require.NoError(t, NewDownloadChecker(store, artifacts).applyRetention())
+	assert.Equal(t, []string{"5.3", "5.2"}, pluginVersionNames(t, store, "akismet/akismet.php"))
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rollback offers a stored older release of a plugin or theme to the sites
+// it targets instead of the newest one. Without sites and groups it targets
+// every site.
+type Rollback struct {
+	Type    string `json:"type"`
+	Slug    string `json:"slug"`
+	Version string `json:"version"`
+	// Sites are host patterns, e.g. "shop.example.com" or "*.example.org"
+	Sites []string `json:"sites,omitempty"`
+	// Groups are names of cfg.Sites.Groups
+	Groups    []string  `json:"groups,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func rollbackKey(kind, slug string) string {
+	return kind + ":" + slug
+}
+
+func (r Rollback) key() string {
+	return rollbackKey(r.Type, r.Slug)
+}
+
+// targets reports whether the rollback applies to the site with host
+func (r Rollback) targets(host string) bool {
+	if len(r.Sites) == 0 && len(r.Groups) == 0 {
+		return true
+	}
+	if host == "" {
+		return false
+	}
+	if matchesAny(r.Sites, host) {
+		return true
+	}
+	for _, group := range r.Groups {
+		if matchesAny(cfg.Sites.Groups[group], host) {
+			return true
+		}
+	}
+	return false
+}
+
+// siteHost returns the host of the site making a WordPress API request,
+// which WordPress sends in its User-Agent: "WordPress/6.4.2; https://example.com"
+func siteHost(c *gin.Context) string {
+	_, siteURL, ok := strings.Cut(c.GetHeader("User-Agent"), ";")
+	if !ok {
+		return ""
+	}
+	u, err := url.Parse(strings.TrimSpace(siteURL))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// pluginRollback returns the release a rollback offers the site with host
+// for a plugin file, if there is one
+func (s *Server) pluginRollback(host, pluginFile string) (*PluginVersion, bool) {
+	slug := pluginSlug(pluginFile)
+	rollback, err := s.store.GetRollback(pluginDirectory.kind, slug)
+	if err != nil {
+		if !errors.Is(err, ErrRollbackNotFound) {
+			log.Printf("Error retrieving rollback for plugin %s: %v", slug, err)
+		}
+		return nil, false
+	}
+	if !rollback.targets(host) {
+		return nil, false
+	}
+
+	versions, err := storedPluginVersions(s.store, pluginFile)
+	if err != nil {
+		log.Printf("Error retrieving plugin versions for %s: %v", pluginFile, err)
+		return nil, false
+	}
+	for i, v := range versions {
+		if v.NewVersion == rollback.Version {
+			return &versions[i], true
+		}
+	}
+	log.Printf("Rollback of plugin %s to %s is not stored", slug, rollback.Version)
+	return nil, false
+}
+
+// themeRollback returns the release a rollback offers the site with host
+// for a theme, if there is one
+func (s *Server) themeRollback(host, themeSlug string) (*ThemeVersion, bool) {
+	rollback, err := s.store.GetRollback(themeDirectory.kind, themeSlug)
+	if err != nil {
+		if !errors.Is(err, ErrRollbackNotFound) {
+			log.Printf("Error retrieving rollback for theme %s: %v", themeSlug, err)
+		}
+		return nil, false
+	}
+	if !rollback.targets(host) {
+		return nil, false
+	}
+
+	versions, err := s.store.GetThemeVersions(themeSlug)
+	if err != nil {
+		log.Printf("Error retrieving theme versions for %s: %v", themeSlug, err)
+		return nil, false
+	}
+	for i, v := range versions {
+		if v.NewVersion == rollback.Version {
+			return &versions[i], true
+		}
+	}
+	log.Printf("Rollback of theme %s to %s is not stored", themeSlug, rollback.Version)
+	return nil, false
+}
+
+// Release is one entry of the version history API
+type Release struct {
+	Version  string `json:"version"`
+	Released string `json:"released,omitempty"`
+	Package  string `json:"package"`
+}
+
+// VersionHistory is the body of the version history API
+type VersionHistory struct {
+	Slug     string    `json:"slug"`
+	Latest   string    `json:"latest"`
+	Versions []Release `json:"versions"`
+	Rollback *Rollback `json:"rollback,omitempty"`
+}
+
+// handlePluginVersions lists the stored in-scope releases of a plugin,
+// newest first, with its rollback if one is set
+func (s *Server) handlePluginVersions(c *gin.Context) {
+	slug := c.Param("slug")
+	versions, err := storedPluginVersions(s.store, slug)
+	if err != nil {
+		log.Printf("Error retrieving plugin versions for %s: %v", slug, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve plugin versions"})
+		return
+	}
+
+	var releases []Release
+	for _, v := range versions {
+		if cfg.Scope.Plugins.AllowsVersion(slug, v.NewVersion) {
+			releases = append(releases, Release{Version: v.NewVersion, Released: v.Released, Package: v.Package})
+		}
+	}
+	s.writeVersionHistory(c, pluginDirectory.kind, slug, releases)
+}
+
+// handleThemeVersions lists the stored in-scope releases of a theme, newest
+// first, with its rollback if one is set
+func (s *Server) handleThemeVersions(c *gin.Context) {
+	slug := c.Param("slug")
+	versions, err := s.store.GetThemeVersions(slug)
+	if err != nil {
+		log.Printf("Error retrieving theme versions for %s: %v", slug, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve theme versions"})
+		return
+	}
+
+	var releases []Release
+	for _, v := range versions {
+		if cfg.Scope.Themes.AllowsVersion(slug, v.NewVersion) {
+			releases = append(releases, Release{Version: v.NewVersion, Released: v.Released, Package: v.Package})
+		}
+	}
+	s.writeVersionHistory(c, themeDirectory.kind, slug, releases)
+}
+
+func (s *Server) writeVersionHistory(c *gin.Context, kind, slug string, releases []Release) {
+	if len(releases) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No versions found"})
+		return
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return CompareVersions(releases[i].Version, releases[j].Version) > 0
+	})
+
+	history := VersionHistory{Slug: slug, Latest: releases[0].Version, Versions: releases}
+	rollback, err := s.store.GetRollback(kind, slug)
+	if err == nil {
+		history.Rollback = rollback
+	} else if !errors.Is(err, ErrRollbackNotFound) {
+		log.Printf("Error retrieving rollback for %s %s: %v", kind, slug, err)
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// requireAdmin rejects requests without the admin bearer token, and every
+// request if no token is configured
+func requireAdmin(c *gin.Context) {
+	if cfg.Server.AdminToken == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin API disabled"})
+		return
+	}
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Server.AdminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+		return
+	}
+	c.Next()
+}
+
+func (s *Server) handleListRollbacks(c *gin.Context) {
+	rollbacks, err := s.store.ListRollbacks()
+	if err != nil {
+		log.Printf("Error listing rollbacks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list rollbacks"})
+		return
+	}
+	c.JSON(http.StatusOK, rollbacks)
+}
+
+// handleSetRollback stores the rollback of /admin/rollbacks/:type/:slug.
+// The version must be stored so the sites are offered a real package.
+func (s *Server) handleSetRollback(c *gin.Context) {
+	var rollback Rollback
+	if err := c.ShouldBindJSON(&rollback); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	rollback.Type = c.Param("type")
+	rollback.Slug = c.Param("slug")
+	rollback.CreatedAt = time.Now().UTC()
+
+	for _, group := range rollback.Groups {
+		if _, ok := cfg.Sites.Groups[group]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown site group " + group})
+			return
+		}
+	}
+	for _, pattern := range rollback.Sites {
+		if _, err := path.Match(pattern, ""); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid site pattern " + pattern})
+			return
+		}
+	}
+
+	stored, err := s.isStoredRelease(rollback.Type, rollback.Slug, rollback.Version)
+	if err != nil {
+		log.Printf("Error checking %s %s %s: %v", rollback.Type, rollback.Slug, rollback.Version, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check version"})
+		return
+	}
+	if !stored {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Version not stored"})
+		return
+	}
+
+	if err := s.store.SetRollback(rollback); err != nil {
+		log.Printf("Error storing rollback for %s %s: %v", rollback.Type, rollback.Slug, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store rollback"})
+		return
+	}
+	log.Printf("Rolling back %s %s to %s", rollback.Type, rollback.Slug, rollback.Version)
+	c.JSON(http.StatusOK, rollback)
+}
+
+func (s *Server) handleDeleteRollback(c *gin.Context) {
+	kind, slug := c.Param("type"), c.Param("slug")
+	if err := s.store.DeleteRollback(kind, slug); err != nil {
+		log.Printf("Error deleting rollback for %s %s: %v", kind, slug, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rollback"})
+		return
+	}
+	log.Printf("Removed rollback of %s %s", kind, slug)
+	c.Status(http.StatusNoContent)
+}
+
+// isStoredRelease reports whether version of the plugin or theme slug is
+// stored
+func (s *Server) isStoredRelease(kind, slug, version string) (bool, error) {
+	switch kind {
+	case pluginDirectory.kind:
+		versions, err := storedPluginVersions(s.store, slug)
+		if err != nil {
+			return false, err
+		}
+		for _, v := range versions {
+			if v.NewVersion == version {
+				return true, nil
+			}
+		}
+	case themeDirectory.kind:
+		versions, err := s.store.GetThemeVersions(slug)
+		if err != nil {
+			return false, err
+		}
+		for _, v := range versions {
+			if v.NewVersion == version {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupRollbackServer(t *testing.T) (*MemoryStore, http.Handler) {
+	previous := cfg
+	t.Cleanup(func() { cfg = previous })
+	cfg = DefaultConfig()
+	cfg.Server.AdminToken = "secret"
+	cfg.Sites.Groups = map[string][]string{"canary": {"*.canary.example.com"}}
+
+	store := NewMemoryStore()
+	require.NoError(t, store.SetPluginVersions("akismet", []PluginVersion{
+		{Slug: "akismet", NewVersion: "5.3", Package: "https://downloads.wordpress.org/plugin/akismet.5.3.zip", Released: "2024-01-10"},
+		{Slug: "akismet", NewVersion: "5.2", Package: "https://downloads.wordpress.org/plugin/akismet.5.2.zip"},
+	}))
+	return store, NewServer(store, NewFileArtifactStore(cfg.Paths)).setupRouter()
+}
+
+func pluginUpdateCheck(t *testing.T, router http.Handler, site, version string) PluginUpdateCheckResponse {
+	plugins := `{"plugins":{"akismet/akismet.php":{"Version":"` + version + `"}}}`
+	form := url.Values{"plugins": {plugins}, "all": {"true"}}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/plugins/update-check/1.1/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "WordPress/6.4.2; "+site)
+	router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	var response PluginUpdateCheckResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func TestPluginVersionHistory(t *testing.T) {
+	_, router := setupRollbackServer(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/mirror/plugins/akismet/versions/", nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	var history VersionHistory
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	assert.Equal(t, "5.3", history.Latest)
+	assert.Equal(t, []Release{
+		{Version: "5.3", Released: "2024-01-10", Package: "https://downloads.wordpress.org/plugin/akismet.5.3.zip"},
+		{Version: "5.2", Package: "https://downloads.wordpress.org/plugin/akismet.5.2.zip"},
+	}, history.Versions)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/mirror/plugins/missing/versions/", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestPluginReleasesStoredUnderPluginFile(t *testing.T) {
+	store, router := setupRollbackServer(t)
+	require.NoError(t, store.SetPluginVersions("contact-form-7/wp-contact-form-7.php", []PluginVersion{
+		{Slug: "contact-form-7", NewVersion: "5.8.5", Package: "https://downloads.wordpress.org/plugin/contact-form-7.5.8.5.zip"},
+	}))
+	require.NoError(t, store.SetPluginVersions("contact-form-7", []PluginVersion{
+		{Slug: "contact-form-7", NewVersion: "5.8.4", Package: "https://downloads.wordpress.org/plugin/contact-form-7.5.8.4.zip"},
+	}))
+
+	// The history and the rollback check see the versions of both keys
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/mirror/plugins/contact-form-7/versions/", nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+	var history VersionHistory
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	assert.Equal(t, "5.8.5", history.Latest)
+	assert.Len(t, history.Versions, 2)
+
+	for _, version := range []string{"5.8.5", "5.8.4"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("PUT", "/admin/rollbacks/plugin/contact-form-7", strings.NewReader(`{"version":"`+version+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer secret")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code, version)
+	}
+}
+
+func TestRollbackOffer(t *testing.T) {
+	_, router := setupRollbackServer(t)
+
+	setRollback := func(token, body string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/admin/rollbacks/plugin/akismet", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, 401, setRollback("wrong", `{"version":"5.2"}`))
+	assert.Equal(t, 400, setRollback("secret", `{"version":"5.1"}`))
+	assert.Equal(t, 400, setRollback("secret", `{"version":"5.2","groups":["unknown"]}`))
+	require.Equal(t, 200, setRollback("secret", `{"version":"5.2","groups":["canary"],"reason":"5.3 breaks checkout"}`))
+
+	// Sites of the group are offered the rollback, even from a newer version
+	response := pluginUpdateCheck(t, router, "https://shop.canary.example.com/", "5.3")
+	require.Contains(t, response.Plugins, "akismet/akismet.php")
+	assert.Equal(t, "5.2", response.Plugins["akismet/akismet.php"].NewVersion)
+	response = pluginUpdateCheck(t, router, "https://shop.canary.example.com/", "5.2")
+	assert.Empty(t, response.Plugins)
+	assert.Equal(t, "5.2", response.NoUpdate["akismet/akismet.php"].NewVersion)
+
+	// Other sites keep getting the newest version
+	response = pluginUpdateCheck(t, router, "https://www.example.com/", "5.2")
+	assert.Equal(t, "5.3", response.Plugins["akismet/akismet.php"].NewVersion)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/admin/rollbacks/plugin/akismet", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 204, w.Code)
+	response = pluginUpdateCheck(t, router, "https://shop.canary.example.com/", "5.2")
+	assert.Equal(t, "5.3", response.Plugins["akismet/akismet.php"].NewVersion)
+}
+
+func TestAdminAPIDisabledWithoutToken(t *testing.T) {
+	_, router := setupRollbackServer(t)
+	cfg.Server.AdminToken = ""
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/rollbacks/", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 403, w.Code)
+}
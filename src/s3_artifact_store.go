@@ -0,0 +1,397 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm   = "AWS4-HMAC-SHA256"
+	amzDateFormat    = "20060102T150405Z"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// s3Folders maps an artifact type to the key prefix of its objects
+var s3Folders = map[string]string{"core": "core", "plugin": "plugins", "theme": "themes", "blob": "blobs"}
+
+// ArtifactPresigner is implemented by artifact stores that can hand out
+// temporary download URLs, which the server redirects clients to
+type ArtifactPresigner interface {
+	// PresignGet returns a URL downloading the artifact without
+	// credentials until expiry has passed
+	PresignGet(a Artifact, expiry time.Duration) (string, error)
+}
+
+// S3ArtifactStore stores artifacts as objects of an S3-compatible bucket,
+// keyed <prefix><core|plugins|themes|blobs>/<file name>. Requests are signed with
+// AWS Signature Version 4 and use path-style addressing, which AWS, MinIO
+// and most compatible services accept.
+type S3ArtifactStore struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func NewS3ArtifactStore(c S3Config) (*S3ArtifactStore, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(c.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing endpoint: %w", err)
+	}
+	prefix := c.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3ArtifactStore{
+		endpoint:  endpoint,
+		region:    c.Region,
+		bucket:    c.Bucket,
+		prefix:    prefix,
+		accessKey: c.AccessKey,
+		secretKey: c.SecretKey,
+		client:    &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+// key returns the object key of an artifact
+func (s *S3ArtifactStore) key(a Artifact) (string, error) {
+	if err := a.validate(); err != nil {
+		return "", err
+	}
+	return s.prefix + s3Folders[a.Type] + "/" + a.FileName(), nil
+}
+
+// objectURL returns the URL of an object, or of the bucket if key is empty
+func (s *S3ArtifactStore) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	u.Path += "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = awsEscape(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// do sends a signed request for u. body is sent with the given size and
+// SHA-256 payload hash.
+func (s *S3ArtifactStore) do(method string, u *url.URL, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.URL = u
+	if body != nil && size > 0 {
+		req.Body = io.NopCloser(body)
+		req.ContentLength = size
+	}
+	s.sign(req, payloadHash, time.Now())
+	return s.client.Do(req)
+}
+
+// sign adds the Signature Version 4 headers to req
+func (s *S3ArtifactStore) sign(req *http.Request, payloadHash string, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	canonical, signedHeaders := sigV4CanonicalRequest(req.Method, req.URL.EscapedPath(), req.URL.RawQuery, map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           t.Format(amzDateFormat),
+	}, payloadHash)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.accessKey, sigV4Scope(t, s.region), signedHeaders, sigV4Signature(s.secretKey, s.region, t, canonical)))
+}
+
+// presign returns u with the Signature Version 4 query parameters allowing
+// a GET of it until expiry has passed
+func (s *S3ArtifactStore) presign(u *url.URL, t time.Time, expiry time.Duration) string {
+	t = t.UTC()
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", s.accessKey+"/"+sigV4Scope(t, s.region))
+	query.Set("X-Amz-Date", t.Format(amzDateFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expiry/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	signed := *u
+	signed.RawQuery = canonicalQuery(query)
+	canonical, _ := sigV4CanonicalRequest(http.MethodGet, signed.EscapedPath(), signed.RawQuery,
+		map[string]string{"host": u.Host}, unsignedPayload)
+	signed.RawQuery += "&X-Amz-Signature=" + sigV4Signature(s.secretKey, s.region, t, canonical)
+	return signed.String()
+}
+
+// Stat sends a HEAD request for the artifact's object
+func (s *S3ArtifactStore) Stat(a Artifact) (ArtifactInfo, error) {
+	key, err := s.key(a)
+	if err != nil {
+		return ArtifactInfo{}, err
+	}
+	resp, err := s.do(http.MethodHead, s.objectURL(key, nil), nil, 0, emptyPayloadHash)
+	if err != nil {
+		return ArtifactInfo{}, fmt.Errorf("error requesting %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if err := s3Error(resp); err != nil {
+		return ArtifactInfo{}, err
+	}
+	return objectInfo(resp), nil
+}
+
+// Open streams the artifact's object
+func (s *S3ArtifactStore) Open(a Artifact) (io.ReadCloser, ArtifactInfo, error) {
+	key, err := s.key(a)
+	if err != nil {
+		return nil, ArtifactInfo{}, err
+	}
+	resp, err := s.do(http.MethodGet, s.objectURL(key, nil), nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, ArtifactInfo{}, fmt.Errorf("error requesting %s: %w", key, err)
+	}
+	if err := s3Error(resp); err != nil {
+		resp.Body.Close()
+		return nil, ArtifactInfo{}, err
+	}
+	return resp.Body, objectInfo(resp), nil
+}
+
+// Create buffers the artifact in the staging directory, uploaded on Commit since
+// S3 needs the size and hash of an object before its content
+func (s *S3ArtifactStore) Create(a Artifact) (ArtifactWriter, error) {
+	key, err := s.key(a)
+	if err != nil {
+		return nil, err
+	}
+	f, err := createStagingFile()
+	if err != nil {
+		return nil, err
+	}
+	return &s3ArtifactWriter{store: s, key: key, file: f, hash: sha256.New()}, nil
+}
+
+// Delete deletes the artifact's object
+func (s *S3ArtifactStore) Delete(a Artifact) error {
+	key, err := s.key(a)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodDelete, s.objectURL(key, nil), nil, 0, emptyPayloadHash)
+	if err != nil {
+		return fmt.Errorf("error deleting %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if err := s3Error(resp); err != nil && !errors.Is(err, ErrArtifactNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Versions lists the <slug>.<version>.zip objects of a slug
+func (s *S3ArtifactStore) Versions(kind, slug string) ([]string, error) {
+	if err := (Artifact{Type: kind, Slug: slug, Version: "0"}).validate(); err != nil {
+		return nil, err
+	}
+	prefix := s.prefix + s3Folders[kind] + "/" + slug + "."
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+
+	var versions []string
+	for {
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		resp, err := s.do(http.MethodGet, s.objectURL("", query), nil, 0, emptyPayloadHash)
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s: %w", prefix, err)
+		}
+		err = s3Error(resp)
+		if err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&page)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s: %w", prefix, err)
+		}
+
+		for _, object := range page.Contents {
+			version := strings.TrimPrefix(object.Key, prefix)
+			if strings.HasSuffix(version, ".zip") && !strings.Contains(version, "/") {
+				versions = append(versions, strings.TrimSuffix(version, ".zip"))
+			}
+		}
+		if !page.IsTruncated {
+			return versions, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// PresignGet returns a presigned GET URL of the artifact's object
+func (s *S3ArtifactStore) PresignGet(a Artifact, expiry time.Duration) (string, error) {
+	key, err := s.key(a)
+	if err != nil {
+		return "", err
+	}
+	return s.presign(s.objectURL(key, nil), time.Now(), expiry), nil
+}
+
+type s3ArtifactWriter struct {
+	store *S3ArtifactStore
+	key   string
+	file  *os.File
+	hash  hash.Hash
+	size  int64
+}
+
+func (w *s3ArtifactWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.hash.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *s3ArtifactWriter) Commit() error {
+	defer w.Abort()
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error reading file: %w", err)
+	}
+	resp, err := w.store.do(http.MethodPut, w.store.objectURL(w.key, nil), w.file, w.size, hex.EncodeToString(w.hash.Sum(nil)))
+	if err != nil {
+		return fmt.Errorf("error uploading %s: %w", w.key, err)
+	}
+	defer resp.Body.Close()
+	if err := s3Error(resp); err != nil {
+		return fmt.Errorf("error uploading %s: %w", w.key, err)
+	}
+	return nil
+}
+
+func (w *s3ArtifactWriter) Abort() error {
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
+
+// s3Error returns ErrArtifactNotFound for a 404 response and an error with
+// the S3 error code for other unsuccessful responses
+func s3Error(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrArtifactNotFound
+	}
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	var body struct {
+		Code    string
+		Message string
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	if body.Code != "" {
+		return fmt.Errorf("bad status: %s (%s: %s)", resp.Status, body.Code, body.Message)
+	}
+	return fmt.Errorf("bad status: %s", resp.Status)
+}
+
+// objectInfo returns the artifact info of an object response
+func objectInfo(resp *http.Response) ArtifactInfo {
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return ArtifactInfo{Size: resp.ContentLength, ModTime: modTime}
+}
+
+// awsEscape percent-encodes every byte of s except the unreserved
+// characters, and slashes unless encodeSlash is set
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery returns the query string with sorted, encoded parameters
+// Signature Version 4 signs
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// sigV4CanonicalRequest returns the canonical request signing the given
+// lower-case headers, and their names as listed in SignedHeaders
+func sigV4CanonicalRequest(method, path, query string, headers map[string]string, payloadHash string) (canonical, signedHeaders string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders = strings.Join(names, ";")
+	return strings.Join([]string{method, path, query, b.String(), signedHeaders, payloadHash}, "\n"), signedHeaders
+}
+
+func sigV4Scope(t time.Time, region string) string {
+	return t.Format("20060102") + "/" + region + "/s3/aws4_request"
+}
+
+// sigV4Signature signs a canonical request made at t
+func sigV4Signature(secretKey, region string, t time.Time, canonicalRequest string) string {
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		t.Format(amzDateFormat),
+		sigV4Scope(t, region),
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{t.Format("20060102"), region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	return hex.EncodeToString(key)
+}
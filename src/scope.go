@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ScopeConfig limits which plugins and themes the mirror tracks and serves
+type ScopeConfig struct {
+	Plugins ScopeRules `yaml:"plugins"`
+	Themes  ScopeRules `yaml:"themes"`
+}
+
+// ScopeRules select slugs by glob patterns. A slug is in scope if it matches
+// an include pattern, or there are none, and no exclude pattern. Pins hold a
+// slug at a maximum version: "8.x" allows every 8.* release, "8.5.2" allows
+// nothing newer than 8.5.2.
+type ScopeRules struct {
+	Include []string          `yaml:"include,omitempty"`
+	Exclude []string          `yaml:"exclude,omitempty"`
+	Pins    map[string]string `yaml:"pins,omitempty"`
+}
+
+// Allows reports whether slug is in scope
+func (r ScopeRules) Allows(slug string) bool {
+	if matchesAny(r.Exclude, slug) {
+		return false
+	}
+	return len(r.Include) == 0 || matchesAny(r.Include, slug)
+}
+
+// AllowsVersion reports whether slug is in scope and version is not above
+// its pin
+func (r ScopeRules) AllowsVersion(slug, version string) bool {
+	if !r.Allows(slug) {
+		return false
+	}
+	pin, ok := r.Pins[slug]
+	if !ok {
+		return true
+	}
+	if branch, ok := pinnedBranch(pin); ok {
+		return CompareVersions(version, branch) < 0 || version == branch || strings.HasPrefix(version, branch+".")
+	}
+	return CompareVersions(version, pin) <= 0
+}
+
+// pinnedBranch returns "8" for the branch pins "8.x" and "8.*"
+func pinnedBranch(pin string) (string, bool) {
+	for _, suffix := range []string{".x", ".*"} {
+		if strings.HasSuffix(pin, suffix) {
+			return strings.TrimSuffix(pin, suffix), true
+		}
+	}
+	return "", false
+}
+
+// validate returns an error for every malformed pattern or pin
+func (r ScopeRules) validate(name string) []error {
+	var errs []error
+	for _, pattern := range append(append([]string{}, r.Include...), r.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid pattern %q", name, pattern))
+		}
+	}
+	for slug, pin := range r.Pins {
+		if pin == "" || pin == ".x" || pin == ".*" {
+			errs = append(errs, fmt.Errorf("%s: empty pin for %q", name, slug))
+		}
+	}
+	return errs
+}
+
+func matchesAny(patterns []string, slug string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, slug); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// storedPluginVersions returns the stored versions of a plugin file and,
+// for versions the file has no record of, those of its slug, looked up in
+// the same order as latestAllowedPluginVersion. A bare slug is looked up
+// under the plugin files it is the slug of first.
+func storedPluginVersions(store MetadataStore, plugin string) ([]PluginVersion, error) {
+	slug := pluginSlug(plugin)
+	keys := []string{plugin}
+	if plugin == slug {
+		files, err := store.ListAllPluginFiles()
+		if err != nil {
+			return nil, err
+		}
+		keys = nil
+		for _, file := range files {
+			if file != slug && pluginSlug(file) == slug {
+				keys = append(keys, file)
+			}
+		}
+	}
+	keys = append(keys, slug)
+
+	var versions []PluginVersion
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		stored, err := store.GetPluginVersions(key)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving plugin versions for %s: %w", key, err)
+		}
+		for _, v := range stored {
+			if !seen[v.NewVersion] {
+				seen[v.NewVersion] = true
+				versions = append(versions, v)
+			}
+		}
+	}
+	return versions, nil
+}
+
+// latestAllowedPluginVersion returns the newest stored version of a plugin
+// file that the plugin scope allows, falling back to the plugin's slug since
+// the updater stores plugins under their slug
+func latestAllowedPluginVersion(store MetadataStore, pluginFile string) (*PluginVersion, error) {
+	slug := pluginSlug(pluginFile)
+	if !cfg.Scope.Plugins.Allows(slug) {
+		return nil, ErrNoPluginVersions
+	}
+
+	for _, key := range []string{pluginFile, slug} {
+		versions, err := store.GetPluginVersions(key)
+		if err != nil {
+			return nil, err
+		}
+
+		var latest *PluginVersion
+		for i, v := range versions {
+			if !cfg.Scope.Plugins.AllowsVersion(slug, v.NewVersion) {
+				continue
+			}
+			if latest == nil || CompareVersions(v.NewVersion, latest.NewVersion) > 0 {
+				latest = &versions[i]
+			}
+		}
+		if latest != nil {
+			return latest, nil
+		}
+	}
+	return nil, ErrNoPluginVersions
+}
+
+// latestAllowedThemeVersion returns the newest stored version of a theme
+// that the theme scope allows
+func latestAllowedThemeVersion(store MetadataStore, themeSlug string) (*ThemeVersion, error) {
+	if !cfg.Scope.Themes.Allows(themeSlug) {
+		return nil, ErrNoThemeVersions
+	}
+
+	versions, err := store.GetThemeVersions(themeSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *ThemeVersion
+	for i, v := range versions {
+		if !cfg.Scope.Themes.AllowsVersion(themeSlug, v.NewVersion) {
+			continue
+		}
+		if latest == nil || CompareVersions(v.NewVersion, latest.NewVersion) > 0 {
+			latest = &versions[i]
+		}
+	}
+	if latest == nil {
+		return nil, ErrNoThemeVersions
+	}
+	return latest, nil
+}
+
+// newestAllowedVersion returns the newest version of a versions map, as in
+// plugin_information and theme_information, that rules allow for slug
+func newestAllowedVersion(rules ScopeRules, slug string, versions FlexMap) (string, bool) {
+	var newest string
+	for v := range versions {
+		if v == "trunk" || !rules.AllowsVersion(slug, v) {
+			continue
+		}
+		if newest == "" || CompareVersions(v, newest) > 0 {
+			newest = v
+		}
+	}
+	return newest, newest != ""
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopeRules(t *testing.T) {
+	rules := ScopeRules{
+		Include: []string{"woocommerce", "woocommerce-*", "akismet"},
+		Exclude: []string{"woocommerce-legacy-*"},
+		Pins: map[string]string{
+			"woocommerce": "8.x",
+			"akismet":     "5.1",
+		},
+	}
+
+	tests := []struct {
+		slug, version string
+		want          bool
+	}{
+		{"woocommerce", "8.9.3", true},
+		{"woocommerce", "8.0", true},
+		{"woocommerce", "8", true},
+		{"woocommerce", "7.9.1", true},
+		{"woocommerce", "9.0.0", false},
+		{"woocommerce-payments", "9.0.0", true},
+		{"woocommerce-legacy-rest-api", "1.0", false},
+		{"akismet", "5.1", true},
+		{"akismet", "5.0.2", true},
+		{"akismet", "5.1.1", false},
+		{"contact-form-7", "5.7.2", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, rules.AllowsVersion(tt.slug, tt.version), "%s %s", tt.slug, tt.version)
+	}
+
+	assert.True(t, ScopeRules{}.Allows("anything"))
+	assert.NotEmpty(t, ScopeRules{Include: []string{"[woo"}}.validate("scope.plugins"))
+}
+
+func TestNewestAllowedVersion(t *testing.T) {
+	rules := ScopeRules{Pins: map[string]string{"woocommerce": "8.x"}}
+	versions := FlexMap{"7.9.0": "a", "8.9.3": "b", "8.10.0": "c", "9.0.0": "d", "trunk": "e"}
+
+	version, ok := newestAllowedVersion(rules, "woocommerce", versions)
+	assert.True(t, ok)
+	assert.Equal(t, "8.10.0", version)
+}
+
+func TestPluginUpdateCheckHonoursScope(t *testing.T) {
+	previous := cfg
+	t.Cleanup(func() { cfg = previous })
+	cfg = DefaultConfig()
+	cfg.Scope.Plugins.Exclude = []string{"contact-form-*"}
+	cfg.Scope.Plugins.Pins = map[string]string{"akismet": "5.0.x"}
+
+	store := NewMemoryStore()
+	seedDummyData(store)
+	require.NoError(t, store.SetPluginVersions("akismet", []PluginVersion{{Slug: "akismet", NewVersion: "5.0.2"}}))
+	router := NewServer(store, NewFileArtifactStore(cfg.Paths)).setupRouter()
+
+	plugins := `{"plugins":{"akismet/akismet.php":{"Version":"5.0"},"contact-form-7/wp-contact-form-7.php":{"Version":"5.7"}}}`
+	form := url.Values{"plugins": {plugins}}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/plugins/update-check/1.1/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+
+	var response PluginUpdateCheckResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.Plugins, 1)
+	assert.Equal(t, "5.0.2", response.Plugins["akismet/akismet.php"].NewVersion)
+	assert.NotContains(t, response.Plugins, "contact-form-7/wp-contact-form-7.php")
+}
+
+func TestThemeHotTagsHonourScope(t *testing.T) {
+	previous := cfg
+	t.Cleanup(func() { cfg = previous })
+	cfg = DefaultConfig()
+	cfg.Scope.Themes.Exclude = []string{"twentytwentythree"}
+
+	store := NewMemoryStore()
+	seedDummyData(store)
+	router := NewServer(store, NewFileArtifactStore(cfg.Paths)).setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/themes/info/1.1/?action=hot_tags", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var hotTags map[string]HotTag
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &hotTags))
+	assert.Equal(t, 1, hotTags["blog"].Count)
+	assert.NotContains(t, hotTags, "one-column")
+}
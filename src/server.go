@@ -1,86 +1,129 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-const (
-	coreDir    = "/path/to/core/files"
-	pluginsDir = "/path/to/plugins/files"
-	themesDir  = "/path/to/themes/files"
-)
+// Server serves the update API and downloads from a MetadataStore
+type Server struct {
+	store     MetadataStore
+	artifacts ArtifactStore
+	// client fetches the missing artifacts from upstream
+	client  *http.Client
+	fetches fetchGroup
+}
 
-func main() {
-	// Initialize Redis connection
-	err := InitRedis("localhost:6379")
-	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+func NewServer(store MetadataStore, artifacts ArtifactStore) *Server {
+	return &Server{
+		store:     store,
+		artifacts: artifacts,
+		client:    &http.Client{Timeout: 10 * time.Minute},
 	}
+}
 
+// setupRouter registers all API and download routes
+func (s *Server) setupRouter() *gin.Engine {
 	r := gin.Default()
 
 	// Core update check endpoint
-	r.GET("/core-update-check/", handleCoreUpdateCheck)
+	r.GET("/core-update-check/", s.handleCoreUpdateCheck)
+
+	// api.wordpress.org compatible core version check
+	r.GET("/core/version-check/1.7/", s.handleCoreVersionCheck)
+	r.POST("/core/version-check/1.7/", s.handleCoreVersionCheck)
+
+	// api.wordpress.org compatible plugin update check
+	r.POST("/plugins/update-check/1.1/", s.handlePluginUpdateCheck)
+
+	// api.wordpress.org compatible theme update check
+	r.POST("/themes/update-check/1.1/", s.handleThemeUpdateCheck)
+
+	// api.wordpress.org compatible plugin information and search
+	r.GET("/plugins/info/1.2/", s.handlePluginsInfo)
+	r.POST("/plugins/info/1.2/", s.handlePluginsInfo)
+
+	// api.wordpress.org compatible theme information, search and tags
+	r.GET("/themes/info/1.1/", s.handleThemesInfo)
+	r.POST("/themes/info/1.1/", s.handleThemesInfo)
 
 	// Plugin info bulk endpoint
-	r.POST("/plugin-info-bulk/", handlePluginInfoBulk)
+	r.POST("/plugin-info-bulk/", s.handlePluginInfoBulk)
 
 	// Theme info bulk endpoint
-	r.POST("/theme-info-bulk/", handleThemeInfoBulk)
+	r.POST("/theme-info-bulk/", s.handleThemeInfoBulk)
 
 	// Core download endpoint
-	r.GET("/core/:version.zip", handleCoreDownload)
+	r.GET("/core/:version.zip", s.handleCoreDownload)
 
 	// Plugin download endpoint
-	r.GET("/plugins/:plugin-slug/:version.zip", handlePluginDownload)
+	r.GET("/plugins/:plugin-slug/:version.zip", s.handlePluginDownload)
 
 	// Theme download endpoint
-	r.GET("/themes/:theme-slug/:version.zip", handleThemeDownload)
+	r.GET("/themes/:theme-slug/:version.zip", s.handleThemeDownload)
 
-	r.Run(":8080")
+	// Stored release history of a plugin or theme
+	r.GET("/mirror/plugins/:slug/versions/", s.handlePluginVersions)
+	r.GET("/mirror/themes/:slug/versions/", s.handleThemeVersions)
+
+	// Rollback management, behind the admin token
+	admin := r.Group("/admin", requireAdmin)
+	admin.GET("/rollbacks/", s.handleListRollbacks)
+	admin.PUT("/rollbacks/:type/:slug", s.handleSetRollback)
+	admin.DELETE("/rollbacks/:type/:slug", s.handleDeleteRollback)
+
+	return r
+}
+
+// runServer starts the HTTP server and blocks until it exits
+func (s *Server) runServer(addr string) error {
+	return s.setupRouter().Run(addr)
 }
 
-func handleCoreUpdateCheck(c *gin.Context) {
-	versions, err := GetCoreVersions()
+func (s *Server) handleCoreUpdateCheck(c *gin.Context) {
+	versions, err := s.store.GetCoreVersions()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve core versions"})
 		return
 	}
 
-	if len(versions) == 0 {
+	offers := buildCoreOffers(versions, coreUpdateRequestFromQuery(c))
+	if len(offers) == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "No core versions available"})
 		return
 	}
 
-	// Assuming the latest version is the first in the list
-	latestVersion := versions[0]
-
-	response := gin.H{
-		"updates": []gin.H{
-			{
-				"version":         latestVersion.Version,
-				"php_version":     latestVersion.PHPVersion,
-				"mysql_version":   latestVersion.MySQLVersion,
-				"new_bundled":     latestVersion.NewBundled,
-				"partial_version": latestVersion.PartialVersion,
-				"package":         latestVersion.Package,
-				"current":         latestVersion.Current,
-				"locale":          latestVersion.Locale,
-			},
-		},
+	c.JSON(http.StatusOK, gin.H{"offers": offers})
+}
+
+// handleCoreVersionCheck serves api.wordpress.org's /core/version-check/1.7/
+// with the same query string and response shape, so sites can use the
+// mirror without a custom plugin. Core language packs are not mirrored, so
+// translations is always empty.
+func (s *Server) handleCoreVersionCheck(c *gin.Context) {
+	versions, err := s.store.GetCoreVersions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve core versions"})
+		return
+	}
+
+	offers := buildCoreOffers(versions, coreUpdateRequestFromQuery(c))
+	if offers == nil {
+		offers = []CoreOffer{}
 	}
 
-	c.JSON(http.StatusOK, response)
+	c.JSON(http.StatusOK, CoreVersionCheckResponse{
+		Offers:       offers,
+		Translations: []interface{}{},
+	})
 }
 
-func handlePluginInfoBulk(c *gin.Context) {
+func (s *Server) handlePluginInfoBulk(c *gin.Context) {
 	var requestBody map[string]string
 	if err := c.ShouldBindJSON(&requestBody); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
@@ -89,15 +132,19 @@ func handlePluginInfoBulk(c *gin.Context) {
 
 	response := make(map[string]interface{})
 
-	for pluginFile, pluginSlug := range requestBody {
-		latestVersion, err := GetLatestPluginVersion(pluginFile)
+	for pluginFile, slug := range requestBody {
+		latestVersion, err := s.latestPluginVersion(pluginFile)
+		if errors.Is(err, ErrNoPluginVersions) {
+			s.recordPluginDemand(pluginFile)
+			continue
+		}
 		if err != nil {
 			log.Printf("Error retrieving plugin info for %s: %v", pluginFile, err)
 			continue
 		}
 
 		response[pluginFile] = gin.H{
-			"slug":        pluginSlug,
+			"slug":        slug,
 			"new_version": latestVersion.NewVersion,
 			"url":         latestVersion.URL,
 			"package":     latestVersion.Package,
@@ -107,7 +154,7 @@ func handlePluginInfoBulk(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
-func handleThemeInfoBulk(c *gin.Context) {
+func (s *Server) handleThemeInfoBulk(c *gin.Context) {
 	var requestBody []string
 	if err := c.ShouldBindJSON(&requestBody); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
@@ -117,7 +164,11 @@ func handleThemeInfoBulk(c *gin.Context) {
 	response := make(map[string]interface{})
 
 	for _, themeSlug := range requestBody {
-		latestVersion, err := GetLatestThemeVersion(themeSlug)
+		latestVersion, err := latestAllowedThemeVersion(s.store, themeSlug)
+		if errors.Is(err, ErrNoThemeVersions) {
+			s.recordThemeDemand(themeSlug)
+			continue
+		}
 		if err != nil {
 			log.Printf("Error retrieving theme info for %s: %v", themeSlug, err)
 			continue
@@ -134,43 +185,34 @@ func handleThemeInfoBulk(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
-func handleCoreDownload(c *gin.Context) {
-	version := c.Param("version")
-	filename := fmt.Sprintf("wordpress-%s.zip", version)
-	filepath := filepath.Join(coreDir, filename)
-
-	if _, err := os.Stat(filepath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
-		return
-	}
+// zipVersion returns the version of a download route, whose last segment
+// is the ":version.zip" parameter
+func zipVersion(c *gin.Context) string {
+	return strings.TrimSuffix(c.Param("version.zip"), ".zip")
+}
 
-	c.File(filepath)
+// handleCoreDownload serves /core/<version>.zip and the localized
+// /core/<version>-<locale>.zip
+func (s *Server) handleCoreDownload(c *gin.Context) {
+	s.serveZip(c, coreArtifact(zipVersion(c)))
 }
 
-func handlePluginDownload(c *gin.Context) {
+func (s *Server) handlePluginDownload(c *gin.Context) {
 	pluginSlug := c.Param("plugin-slug")
-	version := c.Param("version")
-	filename := fmt.Sprintf("%s.%s.zip", pluginSlug, version)
-	filepath := filepath.Join(pluginsDir, filename)
-
-	if _, err := os.Stat(filepath); os.IsNotExist(err) {
+	version := zipVersion(c)
+	if !cfg.Scope.Plugins.AllowsVersion(pluginSlug, version) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
-
-	c.File(filepath)
+	s.serveZip(c, Artifact{Type: "plugin", Slug: pluginSlug, Version: version})
 }
 
-func handleThemeDownload(c *gin.Context) {
+func (s *Server) handleThemeDownload(c *gin.Context) {
 	themeSlug := c.Param("theme-slug")
-	version := c.Param("version")
-	filename := fmt.Sprintf("%s.%s.zip", themeSlug, version)
-	filepath := filepath.Join(themesDir, filename)
-
-	if _, err := os.Stat(filepath); os.IsNotExist(err) {
+	version := zipVersion(c)
+	if !cfg.Scope.Themes.AllowsVersion(themeSlug, version) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
-
-	c.File(filepath)
+	s.serveZip(c, Artifact{Type: "theme", Slug: themeSlug, Version: version})
 }
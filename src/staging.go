@@ -0,0 +1,157 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// errInvalidDownload marks downloads that are incomplete or not a readable
+// zip
+var errInvalidDownload = errors.New("invalid download")
+
+// partialMaxAge is how long a partial file must have been left untouched
+// before it is removed at startup. Younger ones may belong to a download
+// another process is still writing.
+const partialMaxAge = time.Hour
+
+// stagingPattern names the files created in the staging directory
+const stagingPattern = "wp-mirror-*.zip.part"
+
+// createStagingFile creates a file in the staging directory, the system
+// temporary directory unless paths.staging_dir is set
+func createStagingFile() (*os.File, error) {
+	if cfg.Paths.StagingDir != "" {
+		if err := os.MkdirAll(cfg.Paths.StagingDir, 0755); err != nil {
+			return nil, fmt.Errorf("error creating staging directory: %w", err)
+		}
+	}
+	f, err := os.CreateTemp(cfg.Paths.StagingDir, stagingPattern)
+	if err != nil {
+		return nil, fmt.Errorf("error creating file: %w", err)
+	}
+	return f, nil
+}
+
+// stagedFile is a download written to the staging directory, with the
+// digests computed while it was written
+type stagedFile struct {
+	*os.File
+	size int64
+	sha1 hash.Hash
+	md5  hash.Hash
+}
+
+// stageDownload writes a download body to the staging directory, copying it
+// to tee as well if it is not nil. The caller discards the staged file.
+func stageDownload(body io.Reader, tee io.Writer) (*stagedFile, error) {
+	f, err := createStagingFile()
+	if err != nil {
+		return nil, err
+	}
+	sf := &stagedFile{File: f, sha1: sha1.New(), md5: md5.New()}
+
+	w := io.MultiWriter(f, sf.sha1, sf.md5)
+	if tee != nil {
+		w = io.MultiWriter(w, tee)
+	}
+	if sf.size, err = io.Copy(w, body); err != nil {
+		sf.discard()
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// The connection closed before the whole body was sent
+			return nil, fmt.Errorf("%w: %v", errInvalidDownload, err)
+		}
+		return nil, fmt.Errorf("error writing file: %w", err)
+	}
+	return sf, nil
+}
+
+// validate checks the staged download is as long as the Content-Length it
+// was sent with, if any, matches the checksums, if any, and is a zip whose
+// central directory can be read
+func (sf *stagedFile) validate(contentLength int64, sums *coreChecksums) error {
+	if contentLength >= 0 && sf.size != contentLength {
+		return fmt.Errorf("%w: got %d of %d bytes", errInvalidDownload, sf.size, contentLength)
+	}
+	if sums != nil {
+		if err := sums.verify(sf.File, sf.size, hex.EncodeToString(sf.sha1.Sum(nil)), hex.EncodeToString(sf.md5.Sum(nil))); err != nil {
+			return err
+		}
+	}
+	if _, err := zip.NewReader(sf.File, sf.size); err != nil {
+		return fmt.Errorf("%w: unreadable zip: %v", errInvalidDownload, err)
+	}
+	return nil
+}
+
+// publish stores the staged download as an artifact, which becomes visible
+// on Commit only
+func (sf *stagedFile) publish(artifacts ArtifactStore, a Artifact) error {
+	if _, err := sf.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error reading file: %w", err)
+	}
+	out, err := artifacts.Create(a)
+	if err != nil {
+		return fmt.Errorf("error creating artifact: %w", err)
+	}
+	if _, err := io.Copy(out, sf.File); err != nil {
+		out.Abort()
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return out.Commit()
+}
+
+// discard removes the staged download
+func (sf *stagedFile) discard() {
+	sf.Close()
+	os.Remove(sf.Name())
+}
+
+// partialLister is implemented by artifact stores that keep uncommitted
+// artifacts in files a crashed process leaves behind
+type partialLister interface {
+	Partials() ([]string, error)
+}
+
+// cleanPartials removes the partial files left in the staging directory and
+// the artifact store by processes that died while downloading. It returns
+// the number of files removed.
+func cleanPartials(artifacts ArtifactStore) (int, error) {
+	dir := cfg.Paths.StagingDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	partials, err := filepath.Glob(filepath.Join(dir, stagingPattern))
+	if err != nil {
+		return 0, err
+	}
+	if lister, ok := artifacts.(partialLister); ok {
+		stored, err := lister.Partials()
+		if err != nil {
+			return 0, err
+		}
+		partials = append(partials, stored...)
+	}
+
+	cutoff := time.Now().Add(-partialMaxAge)
+	removed := 0
+	for _, path := range partials {
+		fi, err := os.Stat(path)
+		if err != nil || fi.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("error removing %s: %w", path, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testZip returns a zip holding one file with the given content
+func testZip(t *testing.T, content string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("readme.txt")
+	require.NoError(t, err)
+	_, err = w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestWorkerRejectsInvalidDownloads(t *testing.T) {
+	akismet := testZip(t, "akismet 5.3")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/plugin/akismet.5.3.zip":
+			w.Write(akismet)
+		case "/plugin/truncated.1.0.zip":
+			// The connection closes before the announced length is sent
+			w.Header().Set("Content-Length", strconv.Itoa(len(akismet)))
+			w.Write(akismet[:len(akismet)/2])
+		case "/plugin/html.1.0.zip":
+			w.Write([]byte("<html>Service Unavailable</html>"))
+		}
+	}))
+	defer upstream.Close()
+	setupProxyConfig(t, upstream.URL)
+	store := NewMemoryStore()
+	workers := NewDownloadWorkers(store, NewFileArtifactStore(cfg.Paths))
+
+	for _, slug := range []string{"truncated", "html"} {
+		item := DownloadItem{Type: "plugin", Slug: slug, Version: "1.0", URL: upstream.URL + "/plugin/" + slug + ".1.0.zip"}
+		err := workers.downloadFile(item)
+		assert.ErrorIs(t, err, errInvalidDownload, slug)
+
+		// Nothing is stored and the job is queued again
+		workers.process(0, item)
+		require.Len(t, store.queue, 1, slug)
+		assert.Equal(t, 1, store.queue[0].Attempts)
+		store.queue = nil
+	}
+	_, err := os.Stat(cfg.Paths.PluginsDir)
+	assert.True(t, os.IsNotExist(err))
+
+	item := DownloadItem{Type: "plugin", Slug: "akismet", Version: "5.3", URL: upstream.URL + "/plugin/akismet.5.3.zip"}
+	require.NoError(t, workers.downloadFile(item))
+	stored, err := os.ReadFile(filepath.Join(cfg.Paths.PluginsDir, "akismet.5.3.zip"))
+	require.NoError(t, err)
+	assert.Equal(t, akismet, stored)
+
+	// No partial file is left behind
+	entries, err := os.ReadDir(cfg.Paths.PluginsDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+	entries, err = os.ReadDir(cfg.Paths.StagingDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestStagedFileValidation(t *testing.T) {
+	setupProxyConfig(t, "http://127.0.0.1:1")
+	content := testZip(t, "akismet 5.3")
+
+	staged, err := stageDownload(bytes.NewReader(content), nil)
+	require.NoError(t, err)
+	defer staged.discard()
+	assert.NoError(t, staged.validate(-1, nil))
+	assert.NoError(t, staged.validate(int64(len(content)), nil))
+	assert.ErrorIs(t, staged.validate(int64(len(content))+1, nil), errInvalidDownload)
+
+	// A checksum mismatch is reported as such
+	sums := &coreChecksums{ZipSHA1: "da39a3ee5e6b4b0d3255bfef95601890afd80709"}
+	assert.ErrorIs(t, staged.validate(-1, sums), errChecksumMismatch)
+}
+
+func TestCleanPartials(t *testing.T) {
+	setupProxyConfig(t, "http://127.0.0.1:1")
+	cfg.Paths.BlobsDir = t.TempDir()
+	old := time.Now().Add(-2 * partialMaxAge)
+
+	write := func(path string, modTime time.Time) string {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte("partial"), 0644))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+		return path
+	}
+	crashed := []string{
+		write(filepath.Join(cfg.Paths.StagingDir, "wp-mirror-1.zip.part"), old),
+		write(filepath.Join(cfg.Paths.PluginsDir, ".akismet.5.3.zip.1.part"), old),
+		write(filepath.Join(cfg.Paths.BlobsDir, "ab", ".ab12.1.part"), old),
+	}
+	kept := []string{
+		// Possibly still being written by another process
+		write(filepath.Join(cfg.Paths.StagingDir, "wp-mirror-2.zip.part"), time.Now()),
+		write(filepath.Join(cfg.Paths.ThemesDir, ".twentytwentyfour.1.0.zip.2.part"), time.Now()),
+		write(filepath.Join(cfg.Paths.PluginsDir, "akismet.5.3.zip"), old),
+	}
+
+	artifacts := NewContentStore(NewFileArtifactStore(cfg.Paths), NewMemoryStore())
+	removed, err := cleanPartials(artifacts)
+	require.NoError(t, err)
+	assert.Equal(t, len(crashed), removed)
+	for _, path := range crashed {
+		assert.NoFileExists(t, path)
+	}
+	for _, path := range kept {
+		assert.FileExists(t, path)
+	}
+}
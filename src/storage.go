@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrNoPluginVersions = errors.New("no versions found for the plugin")
+	ErrNoThemeVersions  = errors.New("no versions found for the theme")
+
+	ErrPluginInfoNotFound = errors.New("plugin information not found")
+	ErrThemeInfoNotFound  = errors.New("theme information not found")
+
+	ErrRollbackNotFound = errors.New("rollback not found")
+)
+
+// Structs for storing WordPress information
+type CoreVersion struct {
+	Version        string `json:"version"`
+	PHPVersion     string `json:"php_version"`
+	MySQLVersion   string `json:"mysql_version"`
+	NewBundled     string `json:"new_bundled"`
+	PartialVersion bool   `json:"partial_version"`
+	Package        string `json:"package"`
+	Current        string `json:"current"`
+	Locale         string `json:"locale"`
+}
+
+// key returns the key a core version is stored under: its version, followed
+// by the locale for localized builds, e.g. 6.4.2 or 6.4.2-de_DE
+func (v CoreVersion) key() string {
+	if v.Locale == "" || v.Locale == defaultLocale {
+		return v.Version
+	}
+	return v.Version + "-" + v.Locale
+}
+
+type PluginVersion struct {
+	Slug        string     `json:"slug"`
+	NewVersion  string     `json:"new_version"`
+	URL         string     `json:"url"`
+	Package     string     `json:"package"`
+	Icons       FlexMap    `json:"icons,omitempty"`
+	Banners     FlexMap    `json:"banners,omitempty"`
+	BannersRTL  FlexMap    `json:"banners_rtl,omitempty"`
+	Requires    FlexString `json:"requires,omitempty"`
+	Tested      FlexString `json:"tested,omitempty"`
+	RequiresPHP FlexString `json:"requires_php,omitempty"`
+	// Released is the release date, YYYY-MM-DD, if known
+	Released string `json:"released,omitempty"`
+}
+
+type ThemeVersion struct {
+	Theme       string     `json:"theme"`
+	NewVersion  string     `json:"new_version"`
+	URL         string     `json:"url"`
+	Package     string     `json:"package"`
+	Requires    FlexString `json:"requires,omitempty"`
+	RequiresPHP FlexString `json:"requires_php,omitempty"`
+	// Released is the release date, YYYY-MM-DD, if known
+	Released string `json:"released,omitempty"`
+}
+
+// MetadataStore is the storage backend holding WordPress core, plugin and
+// theme version information together with the download queue. Every
+// component receives its store through its constructor so backends can be
+// swapped without touching the callers.
+type MetadataStore interface {
+	// SetCoreVersions stores the given core versions, keyed by version and
+	// locale
+	SetCoreVersions(versions []CoreVersion) error
+	// GetCoreVersions returns all stored core versions in no particular order
+	GetCoreVersions() ([]CoreVersion, error)
+
+	// SetPluginVersions stores versions for a plugin file, keyed by version
+	SetPluginVersions(pluginFile string, versions []PluginVersion) error
+	// GetPluginVersions returns all stored versions of a plugin file
+	GetPluginVersions(pluginFile string) ([]PluginVersion, error)
+	// DeletePluginVersions removes the given versions of a plugin file
+	DeletePluginVersions(pluginFile string, versions []string) error
+	// GetLatestPluginVersion returns the newest version of a plugin file,
+	// or ErrNoPluginVersions if none is stored
+	GetLatestPluginVersion(pluginFile string) (*PluginVersion, error)
+	// ListAllPluginFiles lists every plugin file with stored versions
+	ListAllPluginFiles() ([]string, error)
+
+	// SetPluginInfo stores the full plugin_information record of a plugin,
+	// keyed by slug
+	SetPluginInfo(info PluginInfo) error
+	// GetPluginInfo returns the full record of a plugin slug, or
+	// ErrPluginInfoNotFound
+	GetPluginInfo(slug string) (*PluginInfo, error)
+	// ListPluginSummaries returns the summary of every stored plugin record
+	ListPluginSummaries() ([]PluginInfo, error)
+
+	// SetThemeInfo stores the full theme_information record of a theme,
+	// keyed by slug
+	SetThemeInfo(info ThemeInfo) error
+	// GetThemeInfo returns the full record of a theme slug, or
+	// ErrThemeInfoNotFound
+	GetThemeInfo(slug string) (*ThemeInfo, error)
+	// ListThemeSummaries returns the summary of every stored theme record
+	ListThemeSummaries() ([]ThemeInfo, error)
+
+	// SetThemeVersions stores versions for a theme slug, keyed by version
+	SetThemeVersions(themeSlug string, versions []ThemeVersion) error
+	// GetThemeVersions returns all stored versions of a theme slug
+	GetThemeVersions(themeSlug string) ([]ThemeVersion, error)
+	// DeleteThemeVersions removes the given versions of a theme slug
+	DeleteThemeVersions(themeSlug string, versions []string) error
+	// GetLatestThemeVersion returns the newest version of a theme slug,
+	// or ErrNoThemeVersions if none is stored
+	GetLatestThemeVersion(themeSlug string) (*ThemeVersion, error)
+	// ListAllThemeSlugs lists every theme slug with stored versions
+	ListAllThemeSlugs() ([]string, error)
+
+	// PushDownload appends an item to the download queue
+	PushDownload(item DownloadItem) error
+	// PopDownload removes the first item of the download queue, blocking
+	// until one is available
+	PopDownload() (DownloadItem, error)
+	// SetDownloadState stores the state of a queued or abandoned item,
+	// keyed by its artifact
+	SetDownloadState(state DownloadState) error
+	// ListDownloadStates returns every stored download state
+	ListDownloadStates() ([]DownloadState, error)
+	// DeleteDownloadState removes the state of an item
+	DeleteDownloadState(key string) error
+
+	// GetCrawlCursor returns the progress of the named directory crawl, the
+	// zero cursor if it never ran
+	GetCrawlCursor(name string) (*CrawlCursor, error)
+	// SetCrawlCursor saves the progress of the named directory crawl
+	SetCrawlCursor(name string, cursor CrawlCursor) error
+
+	// RecordDemand records slug of kind ("plugin" or "theme") as demanded
+	// unless it already is
+	RecordDemand(kind, slug string) error
+	// ListDemands returns the demanded slugs of kind
+	ListDemands(kind string) ([]Demand, error)
+	// SetDemand stores a demand of kind
+	SetDemand(kind string, demand Demand) error
+	// DeleteDemand removes a demanded slug of kind
+	DeleteDemand(kind, slug string) error
+
+	// SetRollback stores a rollback, keyed by its type and slug
+	SetRollback(rollback Rollback) error
+	// GetRollback returns the rollback of a slug of kind, or
+	// ErrRollbackNotFound
+	GetRollback(kind, slug string) (*Rollback, error)
+	// ListRollbacks returns every stored rollback
+	ListRollbacks() ([]Rollback, error)
+	// DeleteRollback removes the rollback of a slug of kind
+	DeleteRollback(kind, slug string) error
+
+	// SetArtifactDigest maps an artifact key to the SHA-256 digest of its
+	// content, replacing any earlier mapping. It returns the replaced
+	// digest if no key maps to it anymore.
+	SetArtifactDigest(key, digest string) (orphan string, err error)
+	// GetArtifactDigest returns the digest of an artifact key, or
+	// ErrArtifactNotFound
+	GetArtifactDigest(key string) (string, error)
+	// ListArtifactDigests returns the digests of the artifact keys starting
+	// with prefix
+	ListArtifactDigests(prefix string) (map[string]string, error)
+	// DeleteArtifactDigest removes the mapping of an artifact key. It
+	// returns the removed digest if no key maps to it anymore.
+	DeleteArtifactDigest(key string) (orphan string, err error)
+
+	// AcquireLock takes the named lock for ttl and reports whether it was
+	// free
+	AcquireLock(key string, ttl time.Duration) (bool, error)
+	// ReleaseLock frees the named lock
+	ReleaseLock(key string) error
+}
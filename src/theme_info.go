@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultHotTagsNumber = 40
+
+// themeFeatureList is the feature_list of the theme directory, grouping the
+// tags the Appearance → Add New feature filter offers
+var themeFeatureList = map[string][]string{
+	"Subject": {
+		"blog", "e-commerce", "education", "entertainment", "food-and-drink",
+		"holiday", "news", "photography", "portfolio",
+	},
+	"Layout": {
+		"grid-layout", "one-column", "two-columns", "three-columns",
+		"four-columns", "left-sidebar", "right-sidebar", "wide-blocks",
+	},
+	"Features": {
+		"accessibility-ready", "block-patterns", "block-styles", "buddypress",
+		"custom-background", "custom-colors", "custom-header", "custom-logo",
+		"custom-menu", "editor-style", "featured-image-header",
+		"featured-images", "flexible-header", "footer-widgets",
+		"front-page-post-form", "full-site-editing", "full-width-template",
+		"microformats", "post-formats", "rtl-language-support", "sticky-post",
+		"style-variations", "template-editing", "theme-options",
+		"threaded-comments", "translation-ready",
+	},
+}
+
+// ThemeInfo is the full theme record of the themes/info/1.1/ API as
+// returned by theme_information. Author is kept raw since it is a user name
+// or, with extended_author, an object.
+type ThemeInfo struct {
+	Name            string          `json:"name"`
+	Slug            string          `json:"slug"`
+	Version         string          `json:"version"`
+	PreviewURL      string          `json:"preview_url"`
+	Author          json.RawMessage `json:"author,omitempty"`
+	ScreenshotURL   string          `json:"screenshot_url"`
+	Rating          float64         `json:"rating"`
+	NumRatings      FlexString      `json:"num_ratings"`
+	ReviewsURL      string          `json:"reviews_url,omitempty"`
+	Downloaded      int             `json:"downloaded"`
+	ActiveInstalls  int             `json:"active_installs"`
+	LastUpdated     string          `json:"last_updated"`
+	LastUpdatedTime string          `json:"last_updated_time,omitempty"`
+	CreationTime    string          `json:"creation_time,omitempty"`
+	Homepage        string          `json:"homepage"`
+	Description     string          `json:"description,omitempty"`
+	Sections        FlexMap         `json:"sections,omitempty"`
+	DownloadLink    string          `json:"download_link"`
+	Tags            FlexMap         `json:"tags"`
+	Versions        FlexMap         `json:"versions,omitempty"`
+	Requires        FlexString      `json:"requires"`
+	RequiresPHP     FlexString      `json:"requires_php"`
+	IsCommercial    bool            `json:"is_commercial"`
+	Parent          json.RawMessage `json:"parent,omitempty"`
+}
+
+// Summary returns the record without the fields query_themes leaves out of
+// its results
+func (info ThemeInfo) Summary() ThemeInfo {
+	info.Sections = nil
+	info.Versions = nil
+	return info
+}
+
+// ThemeVersion returns the update information of the record's current
+// version
+func (info ThemeInfo) ThemeVersion() ThemeVersion {
+	return ThemeVersion{
+		Theme:       info.Slug,
+		NewVersion:  info.Version,
+		URL:         "https://wordpress.org/themes/" + info.Slug + "/",
+		Package:     info.DownloadLink,
+		Requires:    info.Requires,
+		RequiresPHP: info.RequiresPHP,
+		Released:    releaseDate(themeUpdatedTime(info)),
+	}
+}
+
+// AuthorName returns the WordPress.org user name of the theme's author
+func (info ThemeInfo) AuthorName() string {
+	var name string
+	if err := json.Unmarshal(info.Author, &name); err == nil {
+		return name
+	}
+	var author struct {
+		UserNicename string `json:"user_nicename"`
+	}
+	if err := json.Unmarshal(info.Author, &author); err == nil {
+		return author.UserNicename
+	}
+	return ""
+}
+
+// HotTag is one entry of the hot_tags response
+type HotTag struct {
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Count int    `json:"count"`
+}
+
+// handleThemesInfo serves api.wordpress.org's /themes/info/1.1/ for the
+// theme_information, query_themes, hot_tags and feature_list actions from
+// the stored records
+func (s *Server) handleThemesInfo(c *gin.Context) {
+	action := c.Query("action")
+	if action == "" {
+		action = c.PostForm("action")
+	}
+
+	switch action {
+	case "theme_information":
+		s.handleThemeInformation(c)
+	case "query_themes":
+		s.handleQueryThemes(c)
+	case "hot_tags":
+		s.handleThemeHotTags(c)
+	case "feature_list":
+		c.JSON(http.StatusOK, themeFeatureList)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Action not implemented. <a href=\"https://codex.wordpress.org/WordPress.org_API\">API Docs</a>"})
+	}
+}
+
+func (s *Server) handleThemeInformation(c *gin.Context) {
+	slug := requestParam(c, "slug")
+	info, err := s.store.GetThemeInfo(slug)
+	if err == nil && !cfg.Scope.Themes.Allows(slug) {
+		err = ErrThemeInfoNotFound
+	}
+	if err != nil {
+		if !errors.Is(err, ErrThemeInfoNotFound) {
+			log.Printf("Error retrieving theme information for %s: %v", slug, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve theme information"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Theme not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+func (s *Server) handleQueryThemes(c *gin.Context) {
+	themes, err := s.store.ListThemeSummaries()
+	if err != nil {
+		log.Printf("Error listing themes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query themes"})
+		return
+	}
+
+	q := directoryQueryFromRequest(c)
+	matched := filterThemes(themes, q)
+	start, end, info := q.paginate(len(matched))
+
+	c.JSON(http.StatusOK, gin.H{
+		"info":   info,
+		"themes": matched[start:end],
+	})
+}
+
+func (s *Server) handleThemeHotTags(c *gin.Context) {
+	themes, err := s.store.ListThemeSummaries()
+	if err != nil {
+		log.Printf("Error listing themes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list theme tags"})
+		return
+	}
+
+	number := defaultHotTagsNumber
+	if n, err := strconv.Atoi(requestParam(c, "number")); err == nil && n > 0 {
+		number = n
+	}
+
+	// Tags of themes out of scope are not counted, like they are not listed
+	inScope := themes[:0]
+	for _, t := range themes {
+		if cfg.Scope.Themes.Allows(t.Slug) {
+			inScope = append(inScope, t)
+		}
+	}
+
+	hotTags := make(map[string]HotTag)
+	for _, tag := range themeHotTags(inScope, number) {
+		hotTags[tag.Slug] = tag
+	}
+	c.JSON(http.StatusOK, hotTags)
+}
+
+// filterThemes applies the search, tag and author filters and orders the
+// result like the browse parameter asks, most active installs first by
+// default. Unlike plugins, a theme must carry every requested tag, as the
+// feature filter narrows down the results.
+func filterThemes(themes []ThemeInfo, q directoryQuery) []ThemeInfo {
+	search := strings.ToLower(q.Search)
+	author := strings.ToLower(q.Author)
+
+	matched := []ThemeInfo{}
+	for _, t := range themes {
+		if !cfg.Scope.Themes.Allows(t.Slug) {
+			continue
+		}
+		if search != "" && !containsFold(search, t.Name, t.Slug, t.Description, tagText(t.Tags)) {
+			continue
+		}
+		if len(q.Tags) > 0 && !hasAllTags(t.Tags, q.Tags) {
+			continue
+		}
+		if author != "" && strings.ToLower(t.AuthorName()) != author {
+			continue
+		}
+		matched = append(matched, t)
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		switch q.Browse {
+		case "new":
+			return parseWPOrgTime(a.CreationTime).After(parseWPOrgTime(b.CreationTime))
+		case "updated":
+			return parseWPOrgTime(themeUpdatedTime(a)).After(parseWPOrgTime(themeUpdatedTime(b)))
+		default:
+			return a.ActiveInstalls > b.ActiveInstalls
+		}
+	})
+	return matched
+}
+
+// themeUpdatedTime returns the sortable last update time of a theme,
+// preferring last_updated_time over the date-only last_updated
+func themeUpdatedTime(t ThemeInfo) string {
+	if t.LastUpdatedTime != "" {
+		return t.LastUpdatedTime
+	}
+	return t.LastUpdated
+}
+
+func hasAllTags(tags FlexMap, wanted []string) bool {
+	for _, w := range wanted {
+		if _, ok := tags[strings.ToLower(w)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// themeHotTags counts the tags of the themes and returns the number most
+// used ones
+func themeHotTags(themes []ThemeInfo, number int) []HotTag {
+	counts := make(map[string]*HotTag)
+	for _, t := range themes {
+		for slug, name := range t.Tags {
+			tag, ok := counts[slug]
+			if !ok {
+				tag = &HotTag{Name: name, Slug: slug}
+				counts[slug] = tag
+			}
+			tag.Count++
+		}
+	}
+
+	tags := make([]HotTag, 0, len(counts))
+	for _, tag := range counts {
+		tags = append(tags, *tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Slug < tags[j].Slug
+	})
+	if len(tags) > number {
+		tags = tags[:number]
+	}
+	return tags
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PluginUpdate is one plugin entry of the /plugins/update-check/1.1/
+// response, in both its "plugins" and "no_update" objects
+type PluginUpdate struct {
+	ID            string            `json:"id"`
+	Slug          string            `json:"slug"`
+	Plugin        string            `json:"plugin"`
+	NewVersion    string            `json:"new_version"`
+	URL           string            `json:"url"`
+	Package       string            `json:"package"`
+	Icons         map[string]string `json:"icons"`
+	Banners       map[string]string `json:"banners"`
+	BannersRTL    map[string]string `json:"banners_rtl"`
+	Requires      string            `json:"requires"`
+	Tested        string            `json:"tested"`
+	RequiresPHP   string            `json:"requires_php"`
+	Compatibility []interface{}     `json:"compatibility"`
+}
+
+// PluginUpdateCheckResponse is the body of /plugins/update-check/1.1/
+type PluginUpdateCheckResponse struct {
+	Plugins      map[string]PluginUpdate `json:"plugins"`
+	Translations []interface{}           `json:"translations"`
+	NoUpdate     map[string]PluginUpdate `json:"no_update"`
+}
+
+// pluginUpdateCheckRequest is the JSON WordPress sends in the "plugins"
+// form field: the headers of every installed plugin keyed by plugin file
+type pluginUpdateCheckRequest struct {
+	Plugins map[string]struct {
+		Version string `json:"Version"`
+	} `json:"plugins"`
+}
+
+// ThemeUpdate is one theme entry of the /themes/update-check/1.1/
+// response, in both its "themes" and "no_update" objects
+type ThemeUpdate struct {
+	Theme       string `json:"theme"`
+	NewVersion  string `json:"new_version"`
+	URL         string `json:"url"`
+	Package     string `json:"package"`
+	Requires    string `json:"requires"`
+	RequiresPHP string `json:"requires_php"`
+}
+
+// ThemeUpdateCheckResponse is the body of /themes/update-check/1.1/
+type ThemeUpdateCheckResponse struct {
+	Themes       map[string]ThemeUpdate `json:"themes"`
+	NoUpdate     map[string]ThemeUpdate `json:"no_update"`
+	Translations []interface{}          `json:"translations"`
+}
+
+// themeUpdateCheckRequest is the JSON WordPress sends in the "themes" form
+// field: the active stylesheet and the headers of every installed theme
+// keyed by stylesheet
+type themeUpdateCheckRequest struct {
+	Active string `json:"active"`
+	Themes map[string]struct {
+		Version string `json:"Version"`
+	} `json:"themes"`
+}
+
+// handlePluginUpdateCheck serves api.wordpress.org's
+// /plugins/update-check/1.1/. WordPress posts the installed plugins, their
+// translations and locales as JSON encoded form fields. Plugins with a newer
+// stored version are returned in "plugins"; with all=true the up-to-date
+// ones are listed in "no_update". Plugins unknown to the mirror or out of
+// scope are left out, as api.wordpress.org does for plugins it does not
+// host, and pinned plugins are offered their newest version within the pin.
+// A rollback targeting the site replaces the newest version with its own,
+// offered whenever the installed version differs.
+func (s *Server) handlePluginUpdateCheck(c *gin.Context) {
+	var request pluginUpdateCheckRequest
+	if err := json.Unmarshal([]byte(c.PostForm("plugins")), &request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plugins field"})
+		return
+	}
+	includeAll := c.PostForm("all") == "true"
+	host := siteHost(c)
+
+	response := PluginUpdateCheckResponse{
+		Plugins:      make(map[string]PluginUpdate),
+		Translations: []interface{}{},
+		NoUpdate:     make(map[string]PluginUpdate),
+	}
+
+	for pluginFile, installed := range request.Plugins {
+		latestVersion, err := s.latestPluginVersion(pluginFile)
+		if errors.Is(err, ErrNoPluginVersions) {
+			s.recordPluginDemand(pluginFile)
+			continue
+		}
+		if err != nil {
+			log.Printf("Error retrieving plugin info for %s: %v", pluginFile, err)
+			continue
+		}
+
+		needsUpdate := CompareVersions(latestVersion.NewVersion, installed.Version) > 0
+		if rollback, ok := s.pluginRollback(host, pluginFile); ok {
+			latestVersion = rollback
+			needsUpdate = rollback.NewVersion != installed.Version
+		}
+
+		update := newPluginUpdate(pluginFile, latestVersion)
+		if needsUpdate {
+			response.Plugins[pluginFile] = update
+		} else if includeAll {
+			response.NoUpdate[pluginFile] = update
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// latestPluginVersion looks up the newest version of a plugin the scope
+// allows, by plugin file or slug
+func (s *Server) latestPluginVersion(pluginFile string) (*PluginVersion, error) {
+	return latestAllowedPluginVersion(s.store, pluginFile)
+}
+
+// pluginSlug derives the WordPress.org slug from a plugin file, e.g.
+// "akismet" from "akismet/akismet.php" and "hello" from "hello.php"
+func pluginSlug(pluginFile string) string {
+	if i := strings.Index(pluginFile, "/"); i >= 0 {
+		return pluginFile[:i]
+	}
+	return strings.TrimSuffix(pluginFile, ".php")
+}
+
+func newPluginUpdate(pluginFile string, v *PluginVersion) PluginUpdate {
+	slug := v.Slug
+	if slug == "" {
+		slug = pluginSlug(pluginFile)
+	}
+	return PluginUpdate{
+		ID:            "w.org/plugins/" + slug,
+		Slug:          slug,
+		Plugin:        pluginFile,
+		NewVersion:    v.NewVersion,
+		URL:           v.URL,
+		Package:       v.Package,
+		Icons:         nonNilMap(v.Icons),
+		Banners:       nonNilMap(v.Banners),
+		BannersRTL:    nonNilMap(v.BannersRTL),
+		Requires:      string(v.Requires),
+		Tested:        string(v.Tested),
+		RequiresPHP:   string(v.RequiresPHP),
+		Compatibility: []interface{}{},
+	}
+}
+
+func nonNilMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
+
+// handleThemeUpdateCheck serves api.wordpress.org's
+// /themes/update-check/1.1/. Themes whose stored latest version is newer
+// than the installed one are returned in "themes", the others known to the
+// mirror in "no_update". Rollbacks targeting the site apply as for plugins.
+func (s *Server) handleThemeUpdateCheck(c *gin.Context) {
+	var request themeUpdateCheckRequest
+	if err := json.Unmarshal([]byte(c.PostForm("themes")), &request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid themes field"})
+		return
+	}
+
+	response := ThemeUpdateCheckResponse{
+		Themes:       make(map[string]ThemeUpdate),
+		NoUpdate:     make(map[string]ThemeUpdate),
+		Translations: []interface{}{},
+	}
+	host := siteHost(c)
+
+	for themeSlug, installed := range request.Themes {
+		latestVersion, err := latestAllowedThemeVersion(s.store, themeSlug)
+		if errors.Is(err, ErrNoThemeVersions) {
+			s.recordThemeDemand(themeSlug)
+			continue
+		}
+		if err != nil {
+			log.Printf("Error retrieving theme info for %s: %v", themeSlug, err)
+			continue
+		}
+
+		needsUpdate := CompareVersions(latestVersion.NewVersion, installed.Version) > 0
+		if rollback, ok := s.themeRollback(host, themeSlug); ok {
+			latestVersion = rollback
+			needsUpdate = rollback.NewVersion != installed.Version
+		}
+
+		update := ThemeUpdate{
+			Theme:       themeSlug,
+			NewVersion:  latestVersion.NewVersion,
+			URL:         latestVersion.URL,
+			Package:     latestVersion.Package,
+			Requires:    string(latestVersion.Requires),
+			RequiresPHP: string(latestVersion.RequiresPHP),
+		}
+		if needsUpdate {
+			response.Themes[themeSlug] = update
+		} else {
+			response.NoUpdate[themeSlug] = update
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// specialVersionForms ranks the non-numeric version parts like PHP's
+// version_compare. A part matches a form if it starts with the form's name,
+// and the first match wins. Numeric parts rank as "#"; parts matching no
+// form rank below everything else.
+var specialVersionForms = []struct {
+	name  string
+	order int
+}{
+	{"dev", 0},
+	{"alpha", 1},
+	{"a", 1},
+	{"beta", 2},
+	{"b", 2},
+	{"RC", 3},
+	{"rc", 3},
+	{"#", 4},
+	{"pl", 5},
+	{"p", 5},
+}
+
+const unknownVersionForm = -6
+
+// CompareVersions compares two WordPress version strings the way PHP's
+// version_compare does, which is what WordPress itself uses. It returns -1
+// if a is older than b, 0 if they are equal and 1 if a is newer, so that
+// "5.10" > "5.9", "6.4-beta1" < "6.4-RC1" < "6.4" < "6.4.1".
+func CompareVersions(a, b string) int {
+	if a == "" || b == "" {
+		switch {
+		case a == b:
+			return 0
+		case a != "":
+			return 1
+		default:
+			return -1
+		}
+	}
+
+	partsA := strings.Split(canonicalizeVersion(a), ".")
+	partsB := strings.Split(canonicalizeVersion(b), ".")
+
+	i := 0
+	for ; i < len(partsA) && i < len(partsB); i++ {
+		if c := compareVersionParts(partsA[i], partsB[i]); c != 0 {
+			return c
+		}
+	}
+
+	// A longer version is newer if it continues with a number
+	// ("6.4.1" > "6.4") and older if it continues with a pre-release
+	// form ("6.4-beta1" < "6.4").
+	switch {
+	case i < len(partsA):
+		if isNumericVersionPart(partsA[i]) {
+			return 1
+		}
+		return compareVersionParts(partsA[i], "#")
+	case i < len(partsB):
+		if isNumericVersionPart(partsB[i]) {
+			return -1
+		}
+		return compareVersionParts("#", partsB[i])
+	}
+	return 0
+}
+
+// canonicalizeVersion rewrites a version so its parts are separated by
+// dots: "-", "_", "+" and other non-alphanumerics become ".", and a "." is
+// inserted wherever digits and letters meet ("6.4RC1" -> "6.4.RC.1").
+func canonicalizeVersion(v string) string {
+	var sb strings.Builder
+	sb.Grow(len(v) * 2)
+
+	last := rune(v[0])
+	sb.WriteRune(last)
+	lastOut := last
+	for _, r := range v[1:] {
+		switch {
+		case r == '-' || r == '_' || r == '+':
+			if lastOut != '.' {
+				sb.WriteByte('.')
+				lastOut = '.'
+			}
+		case (isNonDigit(last) && isDigit(r)) || (isDigit(last) && isNonDigit(r)):
+			if lastOut != '.' {
+				sb.WriteByte('.')
+			}
+			sb.WriteRune(r)
+			lastOut = r
+		case !isAlphanumeric(r):
+			if lastOut != '.' {
+				sb.WriteByte('.')
+				lastOut = '.'
+			}
+		default:
+			sb.WriteRune(r)
+			lastOut = r
+		}
+		last = r
+	}
+	return sb.String()
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isAlphanumeric(r rune) bool {
+	return isDigit(r) || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+func isNonDigit(r rune) bool {
+	return !isDigit(r) && r != '.'
+}
+
+func isNumericVersionPart(part string) bool {
+	return part != "" && isDigit(rune(part[0]))
+}
+
+// compareVersionParts compares two canonical version parts
+func compareVersionParts(a, b string) int {
+	numA, numB := isNumericVersionPart(a), isNumericVersionPart(b)
+	switch {
+	case numA && numB:
+		return compareNumericParts(a, b)
+	case numA:
+		return compareInts(versionFormOrder("#"), versionFormOrder(b))
+	case numB:
+		return compareInts(versionFormOrder(a), versionFormOrder("#"))
+	default:
+		return compareInts(versionFormOrder(a), versionFormOrder(b))
+	}
+}
+
+// compareNumericParts compares the leading digits of two parts, tolerating
+// values that overflow int64
+func compareNumericParts(a, b string) int {
+	a = strings.TrimLeft(leadingDigits(a), "0")
+	b = strings.TrimLeft(leadingDigits(b), "0")
+	if len(a) != len(b) {
+		return compareInts(len(a), len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+func leadingDigits(s string) string {
+	for i, r := range s {
+		if !isDigit(r) {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func versionFormOrder(part string) int {
+	for _, form := range specialVersionForms {
+		if strings.HasPrefix(part, form.name) {
+			return form.order
+		}
+	}
+	return unknownVersionForm
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// SortVersions sorts version strings from newest to oldest
+func SortVersions(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return CompareVersions(versions[i], versions[j]) > 0
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		// Dotted segments compare numerically
+		{"5.9", "5.10", -1},
+		{"1.9", "1.10.0", -1},
+		{"6.4.10", "6.4.9", 1},
+		{"6.2.1", "6.2.1", 0},
+		{"2.0", "10.0", -1},
+		{"4.9.25", "5.0", -1},
+
+		// Segments of different lengths
+		{"6.4", "6.4.1", -1},
+		{"6.4", "6.4.0", -1},
+		{"3.0", "3", 1},
+		{"1.0.0.1", "1.0.0", 1},
+
+		// Core pre-releases
+		{"6.4-alpha-56789", "6.4-beta1", -1},
+		{"6.4-beta1", "6.4-beta2", -1},
+		{"6.4-beta4", "6.4-RC1", -1},
+		{"6.4-RC1", "6.4-RC2", -1},
+		{"6.4-RC3", "6.4", -1},
+		{"6.4-RC1", "6.3.2", 1},
+		{"5.0-beta3-43916", "5.0-beta3", 1},
+		{"6.4-rc1", "6.4-RC1", 0},
+
+		// Plugin and theme releases
+		{"1.0-dev", "1.0-alpha", -1},
+		{"2.1.0-b1", "2.1.0-a2", 1},
+		{"8.0.0-rc.2", "8.0.0-rc.10", -1},
+		{"8.0.0-rc.10", "8.0.0", -1},
+		{"1.0pl1", "1.0", 1},
+		{"3.5.2+build.7", "3.5.2", -1},
+		{"1.0.0_beta", "1.0.0.beta", 0},
+		{"1.0-RC2", "1.0RC2", 0},
+
+		// Empty versions sort before anything
+		{"", "", 0},
+		{"", "0.1", -1},
+		{"0.1", "", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"_vs_"+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.want, CompareVersions(tt.a, tt.b))
+			assert.Equal(t, -tt.want, CompareVersions(tt.b, tt.a))
+		})
+	}
+}
+
+func TestSortVersions(t *testing.T) {
+	versions := []string{"6.4-RC1", "5.9", "6.4", "5.10", "6.4-beta2", "6.3.2", "6.4.1"}
+	SortVersions(versions)
+	assert.Equal(t, []string{"6.4.1", "6.4", "6.4-RC1", "6.4-beta2", "6.3.2", "5.10", "5.9"}, versions)
+}
+
+func TestGetLatestPluginVersionUsesVersionOrder(t *testing.T) {
+	store := NewMemoryStore()
+	for _, v := range []string{"1.9", "1.10.0", "1.10.0-beta1"} {
+		assert.NoError(t, store.SetPluginVersions("example/example.php", []PluginVersion{{Slug: "example", NewVersion: v}}))
+	}
+
+	latest, err := store.GetLatestPluginVersion("example/example.php")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.10.0", latest.NewVersion)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// FlexString decodes the loosely typed string fields of the WordPress.org
+// APIs, which send false or a number instead of a string in places
+// (e.g. "requires": false). false and null decode to "".
+type FlexString string
+
+func (s *FlexString) UnmarshalJSON(data []byte) error {
+	switch {
+	case bytes.Equal(data, []byte("null")), bytes.Equal(data, []byte("false")):
+		*s = ""
+		return nil
+	case bytes.Equal(data, []byte("true")):
+		*s = "1"
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		*s = FlexString(str)
+		return nil
+	default:
+		var num json.Number
+		if err := json.Unmarshal(data, &num); err != nil {
+			return err
+		}
+		*s = FlexString(num.String())
+		return nil
+	}
+}
+
+// FlexMap decodes the string maps of the WordPress.org APIs, which PHP
+// serializes as an empty array [] when there are no entries
+type FlexMap map[string]string
+
+func (m *FlexMap) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '[' {
+		var list []json.RawMessage
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		*m = make(FlexMap, len(list))
+		for i, raw := range list {
+			var v FlexString
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return err
+			}
+			(*m)[strconv.Itoa(i)] = string(v)
+		}
+		return nil
+	}
+
+	var raw map[string]FlexString
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*m = make(FlexMap, len(raw))
+	for k, v := range raw {
+		(*m)[k] = string(v)
+	}
+	return nil
+}
+
+// wpOrgTimeLayouts are the date formats used by the WordPress.org APIs,
+// e.g. "2024-01-10 3:11pm GMT" for last_updated and "2005-10-20" for added
+var wpOrgTimeLayouts = []string{
+	"2006-01-02 3:04pm MST",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// releaseDate returns the YYYY-MM-DD date of a WordPress.org time, or ""
+func releaseDate(s string) string {
+	t := parseWPOrgTime(s)
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// parseWPOrgTime parses a WordPress.org date, returning the zero time if
+// the format is not recognised
+func parseWPOrgTime(s string) time.Time {
+	for _, layout := range wpOrgTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}